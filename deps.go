@@ -1,10 +1,19 @@
 package deps
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+	"weak"
 )
 
 var ErrMissingCreate = errors.New("provider missing create function")
@@ -12,15 +21,48 @@ var ErrNoProvider = errors.New("no provider exists for the given type")
 var ErrNotPointer = errors.New("only pointers can be set on a scope")
 var ErrNotFunc = errors.New("only funcs can be invoked")
 var ErrInvalidValue = errors.New("invalid argument for invoke")
+var ErrCircularDependency = errors.New("circular dependency")
+var ErrInvalidConstructor = errors.New("constructor must return a pointer and optionally an error")
+var ErrInvalidResult = errors.New("invalid result")
+var ErrAmbiguousProvider = errors.New("multiple providers exist for the given type")
+var ErrCreateTimeout = errors.New("provider create timed out")
+var ErrInvalidAlias = errors.New("alias target is not assignable to the alias type")
+var ErrNotImplemented = errors.New("type does not implement the interface")
+var ErrNilValue = fmt.Errorf("%w: value is nil", ErrInvalidValue)
+var ErrHydrateDepthExceeded = errors.New("hydration exceeded the max depth")
+var ErrScopeFrozen = errors.New("scope is frozen")
+var ErrParentCycle = errors.New("scope would be its own parent")
+var ErrLifetimeViolation = errors.New("value depends on a value with a shorter lifetime")
 
-var global *Scope = new(nil)
+// The max depth of hydration when a scope doesn't specify one.
+const DefaultHydrateMaxDepth = 100
+
+var global atomic.Pointer[Scope]
+
+func init() {
+	global.Store(new(nil))
+}
 
 // Returns the global scope. All scopes created with New() has this scope as the parent.
 // The global Set, Get, Provide, Invoke, & Hydrate functions operate based on providers
 // given to this global scope. All child scopes can return values created globally depending
 // on the provided lifetime.
 func Global() *Scope {
-	return global
+	return global.Load()
+}
+
+// Replaces the global scope until the returned restore function is called, which puts back
+// the previous global scope. If the given scope is nil a new empty scope is used. Scopes
+// created with New() while it's replaced are children of the replacement. This is useful
+// for isolating tests which use the global functions: defer deps.SetGlobal(nil)()
+func SetGlobal(scope *Scope) (restore func()) {
+	if scope == nil {
+		scope = new(nil)
+	}
+	previous := global.Swap(scope)
+	return func() {
+		global.Store(previous)
+	}
 }
 
 // A dynamic provider if a requested type does not have value or provider.
@@ -41,34 +83,237 @@ type Dynamic interface {
 // The reflection type for Dynamic.
 var dynamicType = TypeOf[Dynamic]()
 
+// The reflection type for error.
+var errorType = TypeOf[error]()
+
+// The reflection type for context.Context.
+var contextType = TypeOf[context.Context]()
+
+// The reflection type for Scope.
+var scopeType = TypeOf[Scope]()
+
 // Given a type it returns an instance of it if it implements the Dynamic interface.
 // If it does not, nil is returned.
 func GetDynamic(typ reflect.Type) Dynamic {
 	for typ.Kind() == reflect.Pointer {
 		typ = typ.Elem()
 	}
-	ptr := reflect.PointerTo(typ)
-	if !ptr.Implements(dynamicType) {
+	if !isDynamic(typ) {
 		return nil
 	}
 	val := reflect.New(typ).Interface()
 	return val.(Dynamic)
 }
 
+// Returns whether a pointer to the given type implements the Dynamic interface.
+func isDynamic(typ reflect.Type) bool {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	return reflect.PointerTo(typ).Implements(dynamicType)
+}
+
+// A handle to a value which is resolved from the scope the handle was created in the first
+// time Get is called. When a function is invoked or a value is hydrated with a Lazy[V] it's
+// given a handle without resolving V, which allows values that depend on each other to be
+// created as long as they don't use each other while being created. Copies of a handle share
+// the resolved value.
+type Lazy[V any] struct {
+	scope *Scope
+	state *lazyState[V]
+}
+
+type lazyState[V any] struct {
+	once  sync.Once
+	value *V
+	err   error
+}
+
+var _ Dynamic = &Lazy[int]{}
+
+// Binds the handle to the scope it's being created in. The handle is used after the value
+// which holds it is created, so it doesn't continue the resolution chain or context of the
+// scope it was given.
+func (lazy *Lazy[V]) ProvideDynamic(scope *Scope) error {
+	bound := scope.view()
+	bound.resolving = nil
+	bound.ctx = nil
+	bound.recording = nil
+	lazy.scope = bound
+	lazy.state = &lazyState[V]{}
+	return nil
+}
+
+// Returns the value of the handle, resolving it on the first call. If the handle was not
+// created by a scope ErrNoProvider is returned.
+func (lazy Lazy[V]) Get() (*V, error) {
+	if lazy.state == nil {
+		return nil, ErrNoProvider
+	}
+	lazy.state.once.Do(func() {
+		lazy.state.value, lazy.state.err = GetScoped[V](lazy.scope)
+	})
+	return lazy.state.value, lazy.state.err
+}
+
 // Sets a constant value on the global scope.
 func Set[V any](value *V) {
-	SetScoped(global, value)
+	SetScoped(Global(), value)
 }
 
-// Sets a constant value on the given scope.
+// Sets a constant value on the given scope. Panics with ErrScopeFrozen if the scope is frozen.
 func SetScoped[V any](scope *Scope, value *V) {
-	key := TypeOf[V]()
+	scope.setInstance(binding{typ: TypeOf[V]()}, value)
+}
+
+// Returns a value from the given scope and whether V has a value or provider available to the
+// scope. When V is found but creating it failed the error is returned with true, and when V
+// is not found false is returned without an error.
+func TryGet[V any](scope *Scope) (*V, bool, error) {
+	value, err := GetScoped[V](scope)
+	if err == ErrNoProvider {
+		return nil, false, nil
+	}
+	return value, true, err
+}
+
+// Returns a value from the global scope and panics if there was an error. This is useful
+// for wiring code where a missing dependency is a programmer error.
+func MustGet[V any]() *V {
+	return MustGetScoped[V](Global())
+}
+
+// Returns a value from the given scope and panics if there was an error. This is useful
+// for wiring code where a missing dependency is a programmer error.
+func MustGetScoped[V any](scope *Scope) *V {
+	value, err := GetScoped[V](scope)
+	if err != nil {
+		panic(fmt.Sprintf("deps: getting %s: %v", TypeOf[V](), err))
+	}
+	return value
+}
+
+// Invokes a function with the global scope and panics if its arguments could not be resolved.
+func MustInvoke(fn any) Result {
+	result, err := Invoke(fn)
+	if err != nil {
+		panic(fmt.Sprintf("deps: invoking %s: %v", reflect.TypeOf(fn), err))
+	}
+	return result
+}
+
+// Sets an existing value on the given scope which is freed with the given function when the
+// scope is freed. Unlike a provider the value isn't created again once it's freed.
+//
+//	deps.ProvideValue(scope, db, func(db *sql.DB) error { return db.Close() })
+func ProvideValue[V any](scope *Scope, value *V, free func(value *V) error) {
+	key := binding{typ: TypeOf[V]()}
+	scope.mustNotBeFrozen(key.typ)
+	provider := Provider[V]{
+		Lifetime: LifetimeScope,
+		Create: func(scope *Scope) (*V, error) {
+			return value, nil
+		},
+	}
+	if free != nil {
+		provider.Free = func(scope *Scope, value *V) error {
+			return free(value)
+		}
+	}
+	scope.storeInstance(key, value, &providerLink[V]{
+		key:      key,
+		provider: provider,
+		existing: true,
+	})
+}
+
+// Sets a constant value on the given scope like SetScoped but returns ErrNilValue without
+// setting it if the value is nil.
+func SetChecked[V any](scope *Scope, value *V) error {
+	if value == nil {
+		return fmt.Errorf("%w: %s", ErrNilValue, TypeOf[V]())
+	}
+	if err := scope.checkFrozen(TypeOf[V]()); err != nil {
+		return err
+	}
+	SetScoped(scope, value)
+	return nil
+}
+
+// Returns the value of V from the given scope if it can be resolved, otherwise the given value
+// is set on the scope and returned. If multiple callers set a value at the same time only
+// the first is set and all of them get it, like sync.Map's LoadOrStore. Like TryGet only a
+// missing V is replaced by the given value, an error creating V is returned instead. If the
+// value is nil nothing is set and nil is returned, and if the value has to be set on a frozen
+// scope an error wrapping ErrScopeFrozen is returned.
+func GetOrSet[V any](scope *Scope, value *V) (*V, error) {
+	existing, found, err := TryGet[V](scope)
+	if found || value == nil {
+		return existing, err
+	}
+	stored, err := scope.loadOrStoreInstance(binding{typ: TypeOf[V]()}, value)
+	if err != nil {
+		return nil, err
+	}
+	return stored.(*V), nil
+}
+
+// Returns the value of V from the given scope if it can be resolved, otherwise the value
+// returned by supply is set on the scope and returned. Unlike GetOrSet the value is only
+// supplied when it's needed. Like GetOrSet an error creating V is returned without calling
+// supply. If supply returns nil nothing is set and nil is returned, and if the value has to be
+// set on a frozen scope an error wrapping ErrScopeFrozen is returned.
+//
+//	cache, err := deps.GetOr(scope, func() *Cache { return NewCache() })
+func GetOr[V any](scope *Scope, supply func() *V) (*V, error) {
+	existing, found, err := TryGet[V](scope)
+	if found {
+		return existing, err
+	}
+	return GetOrSet(scope, supply())
+}
+
+// Replaces the value of V on the given scope in one step and returns the previous value, or
+// nil if there wasn't one. Concurrent requests for V get either the previous or the new value.
+// The previous value isn't freed since it may still be in use, but the new value is freed
+// like the previous one would have been. This is useful for reloading values like config.
+// Like SetChecked nothing is replaced and ErrNilValue is returned if the value is nil, and an
+// error wrapping ErrScopeFrozen is returned if the scope is frozen.
+//
+//	previous, err := deps.Swap(scope, reloaded)
+func Swap[V any](scope *Scope, value *V) (*V, error) {
+	key := binding{typ: TypeOf[V]()}
+	if value == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNilValue, key.typ)
+	}
+	if err := scope.checkFrozen(key.typ); err != nil {
+		return nil, err
+	}
+	scope.mutex.Lock()
+	previous, exists := scope.instances[key]
+	if !exists {
+		scope.order = append(scope.order, key)
+	}
 	scope.instances[key] = value
+	scope.mutex.Unlock()
+	scope.emit(Event{Kind: EventSwapped, Type: key.typ})
+	if !exists {
+		return nil, nil
+	}
+	return previous.(*V), nil
+}
+
+// Sets a value on the given scope stored under exactly V regardless of the value's dynamic
+// type. This allows storing a value under an interface it implements.
+//
+//	deps.SetAs[io.Writer](scope, os.Stdout)
+func SetAs[V any](scope *Scope, value V) {
+	scope.setInstance(binding{typ: TypeOf[V]()}, &value)
 }
 
 // Returns a constant value from the global scope.
 func Get[V any]() (*V, error) {
-	return GetScoped[V](global)
+	return GetScoped[V](Global())
 }
 
 // Returns a constant value from the given scope and an error if there was an error
@@ -76,52 +321,44 @@ func Get[V any]() (*V, error) {
 // in this scope or its parent and a dynamic provider is defined that is called.
 // If the result of the dynamic pointer is type V or *V then it's returned without error.
 func GetScoped[V any](scope *Scope) (*V, error) {
-	key := TypeOf[V]()
-	if instance, exists := scope.instances[key]; exists {
-		return instance.(*V), nil
+	instance, err := scope.Get(TypeOf[V]())
+	if err != nil {
+		return nil, err
 	}
-	provider := scope.providers[key]
-	if provider == nil {
-		dynamic := GetDynamic(key)
-		if dynamic != nil {
-			err := dynamic.ProvideDynamic(scope)
-			if err != nil {
-				return nil, err
-			}
-			dyn := any(dynamic)
-			if val, ok := dyn.(*V); ok {
-				return val, nil
-			}
-			if val, ok := dyn.(V); ok {
-				return &val, nil
-			}
-		}
-		if scope.Dynamic != nil {
-			dyn, err := scope.Dynamic(key, scope)
-			if err != nil {
-				return nil, err
-			}
-			if val, ok := dyn.(*V); ok {
-				return val, nil
-			}
-			if val, ok := dyn.(V); ok {
-				return &val, nil
-			}
-		}
-		if scope.parent != nil {
-			par, err := GetScoped[V](scope.parent)
-			if err == nil || err != ErrNoProvider {
-				return par, err
-			}
-		}
-		return nil, ErrNoProvider
+	return instance.(*V), nil
+}
+
+// Frees the value of V cached on the given scope so it's created again the next time it's
+// requested. See Scope.Invalidate for details.
+func Invalidate[V any](scope *Scope) error {
+	return scope.Invalidate(TypeOf[V]())
+}
+
+// Returns whether a value of V can be resolved from the given scope without creating it.
+// See Scope.Has for details.
+func CanResolve[V any](scope *Scope) bool {
+	return scope.Has(TypeOf[V]())
+}
+
+// Returns the value of V already created or set on the given scope or its parents without
+// creating it. See Scope.Peek for details.
+func Peek[V any](scope *Scope) (*V, bool) {
+	instance, exists := scope.Peek(TypeOf[V]())
+	if !exists {
+		return nil, false
 	}
-	instance, err := provider.(*providerLink[V]).provider.Create(scope)
+	return instance.(*V), true
+}
+
+// Returns the value with the given name from the given scope and an error if there was an error
+// trying to create the value. Named values are only provided by named providers on the scope
+// or its parents.
+func GetNamed[V any](scope *Scope, name string) (*V, error) {
+	instance, err := scope.get(binding{typ: TypeOf[V](), name: name})
 	if err != nil {
 		return nil, err
 	}
-	scope.instances[key] = instance
-	return instance, nil
+	return instance.(*V), nil
 }
 
 // Registers a provider on the global scope. A Provider can specify lifetime rules and can handle
@@ -129,31 +366,435 @@ func GetScoped[V any](scope *Scope) (*V, error) {
 // be notified about a potential value change when Invoke is called with a function which accepts
 // the pointer argument.
 func Provide[V any](provider Provider[V]) {
-	ProvideScoped(global, provider)
+	ProvideScoped(Global(), provider)
 }
 
 // Registers a provider on the given scope. A Provider can specify lifetime rules and can handle
 // lazily creating new values and freeing them when their lifetime expires. A provider can also
 // be notified about a potential value change when Invoke is called with a function which accepts
-// the pointer argument.
+// the pointer argument. Panics with ErrScopeFrozen if the scope is frozen.
 func ProvideScoped[V any](scoped *Scope, provider Provider[V]) {
-	key := TypeOf[V]()
+	ProvideNamed(scoped, "", provider)
+}
+
+// Registers a provider on the given scope like ProvideScoped but returns an error wrapping
+// ErrMissingCreate instead of registering it if Create is nil, unless AllowMissingCreate is set.
+// When the scope frees replaced values any error freeing the replaced value is returned.
+func ProvideChecked[V any](scope *Scope, provider Provider[V]) error {
+	if provider.Create == nil && !provider.AllowMissingCreate {
+		return fmt.Errorf("%w: %s", ErrMissingCreate, TypeOf[V]())
+	}
+	return provideNamed(scope, "", provider)
+}
+
+// Registers a provider on the given scope under the given name. A type can have any number of
+// named providers in addition to its unnamed provider, they are resolved with GetNamed or by
+// hydrating a struct field with a `deps:"name"` tag. Panics with ErrScopeFrozen if the scope is
+// frozen. When the scope frees replaced values errors freeing them are only returned by
+// ProvideChecked.
+func ProvideNamed[V any](scoped *Scope, name string, provider Provider[V]) {
+	scoped.mustNotBeFrozen(TypeOf[V]())
+	provideNamed(scoped, name, provider)
+}
+
+// Registers a provider on the given scope under the given name. If the scope frees replaced
+// values the error freeing the value is returned after the provider is registered.
+func provideNamed[V any](scope *Scope, name string, provider Provider[V]) error {
+	key := binding{typ: TypeOf[V](), name: name}
+	if err := scope.checkFrozen(key.typ); err != nil {
+		return err
+	}
+	scope.mutex.Lock()
+	scope.providers[key] = &providerLink[V]{
+		key:      key,
+		provider: provider,
+	}
+	scope.mutex.Unlock()
+	return scope.freeReplaced(key)
+}
+
+// Frees the value of the given binding on this scope if FreeReplaced is set. Only the provider
+// which created the value frees it, values which were set are just removed.
+func (scope *Scope) freeReplaced(key binding) error {
+	if !scope.FreeReplaced {
+		return nil
+	}
+	scope.mutex.RLock()
+	creator := scope.creators[key]
+	scope.mutex.RUnlock()
+	if creator != nil {
+		return creator.free(scope)
+	}
+	scope.removeInstance(key)
+	return nil
+}
+
+// Overrides the provider for V on the given scope until the returned restore function is called.
+// The override shadows any providers for V on parent scopes and any value of V already cached on the
+// scope is freed so the override takes effect immediately. Calling restore frees the value created by
+// the override and puts back the previous provider, or removes the override if there wasn't one.
+// This is useful for replacing real providers with fakes in tests: defer Override(s, fake)()
+func Override[V any](scope *Scope, provider Provider[V]) (restore func()) {
+	key := binding{typ: TypeOf[V]()}
+	scope.mustNotBeFrozen(key.typ)
+	override := &providerLink[V]{
+		key:      key,
+		provider: provider,
+	}
+	previous := scope.swapProvider(key, override)
+	return func() {
+		scope.swapProvider(key, previous)
+	}
+}
+
+// Registers a constructor function as a provider on the given scope. The constructor can accept
+// any arguments which are resolved from the scope when the value is created, and must return a
+// pointer and optionally an error. The provided type is the type the pointer points to and the
+// value lasts forever.
+//
+//	deps.ProvideFunc(scope, func(cfg *Config) (*DB, error) { ... })
+func ProvideFunc(scope *Scope, ctor any) error {
+	return scope.provideFunc(ctor, false)
+}
+
+// Registers a function which computes V from its arguments on the given scope. It's like
+// ProvideFunc except the function must return *V and optionally an error, and V is only
+// created when all arguments of the function can be resolved. Otherwise requesting V returns
+// an error wrapping ErrNoProvider which lists the missing arguments.
+//
+//	deps.ProvideFrom[Client](scope, func(cfg *Config, db *DB) (*Client, error) { ... })
+func ProvideFrom[V any](scope *Scope, fn any) error {
+	fnType := reflect.TypeOf(fn)
+	if fnType == nil || fnType.Kind() != reflect.Func {
+		return ErrNotFunc
+	}
+	if fnType.NumOut() < 1 || fnType.Out(0) != reflect.PointerTo(TypeOf[V]()) {
+		return fmt.Errorf("%w: %s does not return *%s", ErrInvalidConstructor, fnType, TypeOf[V]())
+	}
+	return scope.provideFunc(fn, true)
+}
+
+// Registers the constructor function as a provider on this scope. When strict the constructor
+// is only called when all of its arguments can be resolved.
+func (scope *Scope) provideFunc(ctor any, strict bool) error {
+	ctorValue := reflect.ValueOf(ctor)
+	if ctorValue.Kind() != reflect.Func {
+		return ErrNotFunc
+	}
+	ctorType := ctorValue.Type()
+	if ctorType.NumOut() < 1 || ctorType.NumOut() > 2 || ctorType.Out(0).Kind() != reflect.Pointer {
+		return fmt.Errorf("%w: %s", ErrInvalidConstructor, ctorType)
+	}
+	if ctorType.NumOut() == 2 && ctorType.Out(1) != errorType {
+		return fmt.Errorf("%w: %s", ErrInvalidConstructor, ctorType)
+	}
+	key := binding{typ: ctorType.Out(0).Elem()}
+	if err := scope.checkFrozen(key.typ); err != nil {
+		return err
+	}
+	scope.mutex.Lock()
+	scope.providers[key] = &funcLink{
+		key:    key,
+		ctor:   ctorValue,
+		strict: strict,
+	}
+	scope.mutex.Unlock()
+	return scope.freeReplaced(key)
+}
+
+// Registers a provider for the interface I on the given scope whose create function returns
+// an implementation of I, so the implementation doesn't need to be returned as a pointer to
+// the interface. If I is not an interface or Impl does not implement it an error wrapping
+// ErrNotImplemented is returned.
+//
+//	deps.ProvideInterface[Logger](scope, deps.LifetimeForever, func(scope *deps.Scope) (*FileLogger, error) { ... })
+func ProvideInterface[I any, Impl any](scope *Scope, lifetime Lifetime, create func(scope *Scope) (Impl, error)) error {
+	iface := TypeOf[I]()
+	impl := TypeOf[Impl]()
+	if iface.Kind() != reflect.Interface || !impl.Implements(iface) {
+		return fmt.Errorf("%w: %s does not implement %s", ErrNotImplemented, impl, iface)
+	}
+	ProvideScoped(scope, Provider[I]{
+		Lifetime: lifetime,
+		Create: func(scope *Scope) (*I, error) {
+			value, err := create(scope)
+			if err != nil {
+				return nil, err
+			}
+			implemented := any(value).(I)
+			return &implemented, nil
+		},
+	})
+	return nil
+}
+
+// Makes From resolve to the value of To in the given scope. To or a pointer to To must be
+// assignable to From, otherwise an error wrapping ErrInvalidAlias is returned. The value of
+// To is resolved from the scope requesting From so it keeps the lifetime of its provider.
+//
+//	deps.Alias[io.Writer, bytes.Buffer](scope)
+func Alias[From any, To any](scope *Scope) error {
+	return scope.Alias(TypeOf[From](), TypeOf[To]())
+}
+
+// Makes the from type resolve to the value of the to type in this scope. The to type or a
+// pointer to it must be assignable to the from type and the types must differ, otherwise an
+// error wrapping ErrInvalidAlias is returned.
+func (scope *Scope) Alias(from reflect.Type, to reflect.Type) error {
+	if from == to || (!to.AssignableTo(from) && !reflect.PointerTo(to).AssignableTo(from)) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidAlias, from, to)
+	}
+	if err := scope.checkFrozen(from); err != nil {
+		return err
+	}
+	key := binding{typ: from}
+	scope.mutex.Lock()
+	defer scope.mutex.Unlock()
+	scope.providers[key] = &aliasLink{
+		key: key,
+		to:  binding{typ: to},
+	}
+	return nil
+}
+
+// Adds a decorator for V to the given scope. Decorators are called with values created by
+// providers of V on the scope or its children and can augment or replace the value before
+// it's stored. Decorators on parent scopes are called first and decorators on a scope are
+// called in the order they were added. Since decorators are called when a value is created
+// they are called once per lifetime of the value.
+func ProvideDecorator[V any](scope *Scope, decorate func(scope *Scope, value *V) (*V, error)) {
+	typ := TypeOf[V]()
+	scope.mustNotBeFrozen(typ)
+	scope.mutex.Lock()
+	defer scope.mutex.Unlock()
+	scope.decorators[typ] = append(scope.decorators[typ], func(scope *Scope, value any) (any, error) {
+		decorated, err := decorate(scope, value.(*V))
+		if err != nil {
+			return nil, err
+		}
+		return decorated, nil
+	})
+}
+
+// Registers a factory on the given scope which creates a V for each distinct key. Values
+// are created with GetFactory and cached by key until the scope is freed.
+//
+//	deps.ProvideFactory(scope, func(scope *deps.Scope, shard Shard) (*Connection, error) { ... })
+func ProvideFactory[K comparable, V any](scope *Scope, create func(scope *Scope, key K) (*V, error)) {
+	ProvideScoped(scope, Provider[factory[K, V]]{
+		Create: func(scope *Scope) (*factory[K, V], error) {
+			return &factory[K, V]{
+				create:  create,
+				entries: make(map[K]*factoryEntry[V]),
+			}, nil
+		},
+		Free: func(scope *Scope, value *factory[K, V]) error {
+			value.mutex.Lock()
+			defer value.mutex.Unlock()
+			value.entries = make(map[K]*factoryEntry[V])
+			return nil
+		},
+	})
+}
+
+// Returns the value for the given key from the factory of K and V on the given scope,
+// creating it if it's not cached yet. If there is no factory ErrNoProvider is returned.
+func GetFactory[K comparable, V any](scope *Scope, key K) (*V, error) {
+	f, err := GetScoped[factory[K, V]](scope)
+	if err != nil {
+		return nil, err
+	}
+	return f.get(scope, key)
+}
+
+// Creates and caches values for each distinct key.
+type factory[K comparable, V any] struct {
+	create  func(scope *Scope, key K) (*V, error)
+	mutex   sync.Mutex
+	entries map[K]*factoryEntry[V]
+}
+
+// A value created by a factory for a single key.
+type factoryEntry[V any] struct {
+	once  sync.Once
+	value *V
+	err   error
+}
+
+// Returns the value for the key, creating it once even when requested concurrently. If
+// creating fails the error is returned and the value is created again on the next request.
+func (f *factory[K, V]) get(scope *Scope, key K) (*V, error) {
+	f.mutex.Lock()
+	entry, exists := f.entries[key]
+	if !exists {
+		entry = &factoryEntry[V]{}
+		f.entries[key] = entry
+	}
+	f.mutex.Unlock()
+
+	entry.once.Do(func() {
+		entry.value, entry.err = f.create(scope, key)
+	})
+	if entry.err != nil {
+		f.mutex.Lock()
+		if f.entries[key] == entry {
+			delete(f.entries, key)
+		}
+		f.mutex.Unlock()
+	}
+	return entry.value, entry.err
+}
+
+// Registers a provider on the given scope which creates V in the background. Getting
+// Future[V] starts creating the value and returns immediately, and the value is returned by
+// Future.Await once it's created. The future is cached like any other value so concurrent
+// awaits share a single creation, and when it's freed the created value is given to Free.
+// The value is created like any other provided value, with retries, middleware, decorators
+// and lifetime checks applied.
+//
+//	deps.ProvideAsync(scope, deps.Provider[Index]{Create: buildIndex})
+//	future, _ := deps.GetScoped[deps.Future[Index]](scope)
+//	index, err := future.Await()
+func ProvideAsync[V any](scope *Scope, provider Provider[V]) {
+	inner := &providerLink[V]{
+		key:      binding{typ: TypeOf[V]()},
+		provider: provider,
+	}
+	ProvideScoped(scope, Provider[Future[V]]{
+		Lifetime: provider.Lifetime,
+		Priority: provider.Priority,
+		Tags:     provider.Tags,
+		Create: func(scope *Scope) (*Future[V], error) {
+			future := &Future[V]{done: make(chan struct{})}
+			go func() {
+				defer close(future.done)
+				resolving, err := scope.resolve(inner.key, provider.Lifetime)
+				if err != nil {
+					future.err = err
+					return
+				}
+				value, err := scope.createValue(inner, inner.key, resolving)
+				if err != nil {
+					future.err = err
+				} else {
+					future.value = value.(*V)
+				}
+			}()
+			return future, nil
+		},
+		Free: func(scope *Scope, future *Future[V]) error {
+			value, err := future.Await()
+			if err != nil || provider.Free == nil {
+				return nil
+			}
+			return provider.Free(scope, value)
+		},
+	})
+}
+
+// A value being created in the background by a provider registered with ProvideAsync.
+type Future[V any] struct {
+	done  chan struct{}
+	value *V
+	err   error
+}
+
+// Waits for the value to be created and returns it, or the error returned when creating it.
+func (future *Future[V]) Await() (*V, error) {
+	<-future.done
+	return future.value, future.err
+}
+
+// Registers a function on the given scope which runs at most once, the first time the init
+// with the given key is resolved with Initialize, GetNamed[Init], or a field of type Init tagged
+// with the key. If the function returns an error it's returned to whatever resolved the init
+// and the function runs again the next time the init is resolved.
+//
+//	deps.ProvideInit(scope, "signals", func(scope *deps.Scope) error { ... })
+func ProvideInit(scope *Scope, key string, fn func(scope *Scope) error) {
+	ProvideNamed(scope, key, Provider[Init]{
+		Create: func(scope *Scope) (*Init, error) {
+			if err := fn(scope); err != nil {
+				return nil, err
+			}
+			return &Init{}, nil
+		},
+	})
+}
+
+// Runs the init functions registered with ProvideInit under the given keys on the given scope
+// or its parents in order, unless they already ran. The first error is returned and if a key
+// has no init function an error wrapping ErrNoProvider is returned.
+func Initialize(scope *Scope, keys ...string) error {
+	for _, key := range keys {
+		_, err := GetNamed[Init](scope, key)
+		if err == ErrNoProvider {
+			return fmt.Errorf("%w: init %s", err, key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The value of an init function registered with ProvideInit, which only means it ran.
+type Init struct{}
+
+// Adds a provider to the group of V on the global scope. All members of a group are returned
+// by GetGroup and are given to invoked functions which accept a []V argument.
+func ProvideGroup[V any](provider Provider[V]) {
+	ProvideGroupScoped(Global(), provider)
+}
+
+// Adds a provider to the group of V on the given scope. All members of a group are returned
+// by GetGroupScoped and are given to invoked functions which accept a []V argument. Each
+// member has its own lifetime and is freed like any other provided value. Members are
+// ordered by highest priority first, then by parents first and the order they were added.
+func ProvideGroupScoped[V any](scoped *Scope, provider Provider[V]) {
+	typ := TypeOf[V]()
+	scoped.mustNotBeFrozen(typ)
+	key := binding{typ: typ, member: atomic.AddUint64(&members, 1)}
+	scoped.mutex.Lock()
+	defer scoped.mutex.Unlock()
 	scoped.providers[key] = &providerLink[V]{
 		key:      key,
 		provider: provider,
 	}
+	scoped.groups[typ] = append(scoped.groups[typ], key)
+}
+
+// Returns the values of all members in the group of V from the global scope.
+func GetGroup[V any]() ([]V, error) {
+	return GetGroupScoped[V](Global())
+}
+
+// Returns the values of all members in the group of V from the given scope and its parents.
+// Members are ordered by highest priority first, then members on the furthest parent are
+// first and members on a scope are in the order they were provided. If any member fails to
+// be created its error is returned.
+func GetGroupScoped[V any](scope *Scope) ([]V, error) {
+	instances, err := scope.getGroup(TypeOf[V]())
+	if err != nil {
+		return nil, err
+	}
+	group := make([]V, len(instances))
+	for i, instance := range instances {
+		group[i] = *instance.(*V)
+	}
+	return group, nil
 }
 
 // Invokes a function passing provided values from the global scope as arguments. Any argument
 // types that do not have a constant or provider will get their default value.
 func Invoke(fn any) (Result, error) {
-	return global.Invoke(fn)
+	return Global().Invoke(fn)
 }
 
 // Given a pointer to any value this will traverse it using the global scope and when it finds
 // types of provided values it updates them.
 func Hydrate(value any) error {
-	return global.Hydrate(value)
+	return Global().Hydrate(value)
 }
 
 // Returns the reflect.Type of V
@@ -174,175 +815,2186 @@ const (
 	LifetimeScope
 	// The value will be created for invoke or hydration but immediately freed after that.
 	LifetimeOnce
+	// The value will be created on the global scope no matter which scope requests it, so
+	// there is one value per process. It's freed when the global scope is freed.
+	LifetimeSingleton
+	// The value will be created on the given scope and freed when the context given to
+	// InvokeContext is done, or when scope.Free() is called if that's first. FreeOnce does
+	// not free these values. Without a context it lasts like LifetimeScope.
+	LifetimeContext
+	// The value is created every time it's requested and is never stored in a scope, so it's
+	// never freed by the scope.
+	LifetimeTransient
+)
+
+func (lifetime Lifetime) String() string {
+	switch lifetime {
+	case LifetimeForever:
+		return "forever"
+	case LifetimeScope:
+		return "scope"
+	case LifetimeOnce:
+		return "once"
+	case LifetimeSingleton:
+		return "singleton"
+	case LifetimeContext:
+		return "context"
+	case LifetimeTransient:
+		return "transient"
+	}
+	return fmt.Sprintf("Lifetime(%d)", int(lifetime))
+}
+
+// Returns how long values with the lifetime last relative to other lifetimes, a greater
+// value lasts longer.
+func (lifetime Lifetime) lasts() int {
+	switch lifetime {
+	case LifetimeForever, LifetimeSingleton:
+		return 4
+	case LifetimeScope:
+		return 3
+	case LifetimeContext:
+		return 2
+	case LifetimeOnce:
+		return 1
+	}
+	return 0
+}
+
+// Returns the lifetime as text so it's readable when serialized.
+func (lifetime Lifetime) MarshalText() ([]byte, error) {
+	return []byte(lifetime.String()), nil
+}
+
+// What happened to a value in a scope.
+type EventKind int
+
+const (
+	// A value was created by its provider.
+	EventCreated EventKind = iota
+	// A value already in the scope was returned.
+	EventReused
+	// A value was removed from the scope and freed.
+	EventFreed
+	// A value was created by a deprecated provider for the first time in the scope.
+	EventDeprecated
+	// A value in the scope was replaced with Swap.
+	EventSwapped
 )
 
+func (kind EventKind) String() string {
+	switch kind {
+	case EventCreated:
+		return "created"
+	case EventReused:
+		return "reused"
+	case EventFreed:
+		return "freed"
+	case EventDeprecated:
+		return "deprecated"
+	case EventSwapped:
+		return "swapped"
+	}
+	return fmt.Sprintf("EventKind(%d)", int(kind))
+}
+
+// Describes a value being created, reused, or freed in a scope.
+type Event struct {
+	Kind  EventKind
+	Type  reflect.Type
+	Scope *Scope
+	// How long the value took to create, only set for EventCreated.
+	Elapsed time.Duration
+	// The deprecation message of the provider, only set for EventDeprecated.
+	Message string
+}
+
 type link interface {
 	lifetime() Lifetime
+	priority() int
+	tags() []string
+	deprecated() string
+	seeded() bool
+	retries() (int, time.Duration)
+	dependencies() ([]reflect.Type, bool)
 	get(scope *Scope) (any, error)
-	afterPointerUse(scope *Scope) error
+	create(scope *Scope) (any, error)
+	beforePointerUse(scope *Scope, value any) error
+	afterPointerUse(scope *Scope, value any) error
 	free(scope *Scope) error
+	reset(scope *Scope) (bool, error)
+	refresh(scope *Scope) (bool, error)
 }
 
 type providerLink[V any] struct {
 	provider Provider[V]
-	key      reflect.Type
+	key      binding
+	existing bool
 }
 
 func (link *providerLink[V]) lifetime() Lifetime {
 	return link.provider.Lifetime
 }
 
-func (link *providerLink[V]) get(scope *Scope) (any, error) {
-	value := scope.instances[link.key]
-	if value == nil {
-		if link.provider.Create == nil {
-			return value, ErrMissingCreate
-		}
-		created, err := link.provider.Create(scope)
-		if err != nil {
-			return created, err
-		}
-		scope.instances[link.key] = created
-		value = created
-	}
-	return value.(*V), nil
+func (link *providerLink[V]) priority() int {
+	return link.provider.Priority
 }
 
-func (link *providerLink[V]) afterPointerUse(scope *Scope) error {
-	if link.provider.AfterPointerUse != nil {
-		value := scope.instances[link.key].(*V)
-		return link.provider.AfterPointerUse(scope, value)
-	}
-	return nil
+func (link *providerLink[V]) tags() []string {
+	return link.provider.Tags
 }
 
-func (link *providerLink[V]) free(scope *Scope) error {
-	var err error
-	if link.provider.Free != nil {
-		value := scope.instances[link.key].(*V)
-		err = link.provider.Free(scope, value)
-	}
-	delete(scope.instances, link.key)
-	return err
+func (link *providerLink[V]) deprecated() string {
+	return link.provider.Deprecated
 }
 
-type Provider[V any] struct {
-	Lifetime        Lifetime
-	Create          func(scope *Scope) (*V, error)
-	AfterPointerUse func(scope *Scope, value *V) error
-	Free            func(scope *Scope, value *V) error
+// Values set with ProvideValue existed before they were provided.
+func (link *providerLink[V]) seeded() bool {
+	return link.existing
 }
 
-type Scope struct {
+func (link *providerLink[V]) retries() (int, time.Duration) {
+	return link.provider.Retries, link.provider.RetryDelay
+}
+
+// The dependencies of Create aren't known until it's called.
+func (link *providerLink[V]) dependencies() ([]reflect.Type, bool) {
+	return nil, false
+}
+
+func (link *providerLink[V]) get(scope *Scope) (any, error) {
+	if link.provider.Create == nil {
+		if value, exists := scope.getInstance(link.key); exists {
+			return value, nil
+		}
+		return nil, ErrMissingCreate
+	}
+	return scope.getOrCreate(link, link.key)
+}
+
+// Calls the provider's Create, or if the provider has a Clone and a parent of the scope has a
+// value it's cloned instead. Failed attempts are retried by the scope creating the value.
+func (link *providerLink[V]) create(scope *Scope) (any, error) {
+	if link.provider.Clone != nil {
+		for s := scope.parent; s != nil; s = s.parent {
+			if template, exists := s.getInstance(link.key); exists {
+				return link.provider.Clone(scope, template.(*V))
+			}
+		}
+	}
+	if link.provider.Create == nil {
+		return nil, ErrMissingCreate
+	}
+	return link.attempt(scope)
+}
+
+// Calls the provider's Create once. If the provider has a timeout and Create doesn't return in
+// time an error wrapping ErrCreateTimeout is returned and the value Create eventually returns
+// is freed and discarded.
+func (link *providerLink[V]) attempt(scope *Scope) (any, error) {
+	if link.provider.Timeout <= 0 {
+		return link.provider.Create(scope)
+	}
+	type result struct {
+		value *V
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := link.provider.Create(scope)
+		done <- result{value, err}
+	}()
+	timer := time.NewTimer(link.provider.Timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return nil, r.err
+		}
+		return r.value, nil
+	case <-timer.C:
+		go func() {
+			r := <-done
+			if r.err == nil && r.value != nil && link.provider.Free != nil {
+				link.provider.Free(scope, r.value)
+			}
+		}()
+		return nil, fmt.Errorf("%w: %s after %s", ErrCreateTimeout, link.key, link.provider.Timeout)
+	}
+}
+
+func (link *providerLink[V]) beforePointerUse(scope *Scope, value any) error {
+	if link.provider.BeforePointerUse != nil {
+		return link.provider.BeforePointerUse(scope, value.(*V))
+	}
+	return nil
+}
+
+func (link *providerLink[V]) afterPointerUse(scope *Scope, value any) error {
+	if link.provider.AfterPointerUse != nil {
+		return link.provider.AfterPointerUse(scope, value.(*V))
+	}
+	return nil
+}
+
+// Resets the value in the scope so it can be reused if the provider has a Reset and returns
+// whether it was reset.
+func (link *providerLink[V]) reset(scope *Scope) (bool, error) {
+	if link.provider.Reset == nil {
+		return false, nil
+	}
+	value, exists := scope.getInstance(link.key)
+	if !exists {
+		return true, nil
+	}
+	return true, link.provider.Reset(scope, value.(*V))
+}
+
+// Calls the provider's Refresh with the value on the scope and stores the value it returns
+// when it's changed. A replaced value is freed with the provider's Free.
+func (link *providerLink[V]) refresh(scope *Scope) (bool, error) {
+	if link.provider.Refresh == nil {
+		return false, nil
+	}
+	current, exists := scope.getInstance(link.key)
+	if !exists {
+		return false, nil
+	}
+	refreshed, changed, err := link.provider.Refresh(scope, current.(*V))
+	if err != nil || !changed {
+		return false, err
+	}
+	if refreshed == nil {
+		return false, fmt.Errorf("%w: refreshed %s", ErrNilValue, link.key)
+	}
+	scope.storeInstance(link.key, refreshed, link)
+	if refreshed != current.(*V) {
+		scope.emit(Event{Kind: EventFreed, Type: link.key.typ})
+		if link.provider.Free != nil {
+			return true, link.provider.Free(scope, current.(*V))
+		}
+	}
+	return true, nil
+}
+
+// Removes the value from the scope and frees it. The value is removed atomically before the
+// provider's Free is called so concurrent frees call it at most once per value.
+func (link *providerLink[V]) free(scope *Scope) error {
+	value, exists := scope.removeInstance(link.key)
+	if !exists {
+		return nil
+	}
+	scope.emit(Event{Kind: EventFreed, Type: link.key.typ})
+	if link.provider.Free != nil {
+		return link.provider.Free(scope, value.(*V))
+	}
+	return nil
+}
+
+// A provider registered with ProvideFunc which creates its value by invoking a constructor.
+type funcLink struct {
+	key    binding
+	ctor   reflect.Value
+	strict bool
+}
+
+func (link *funcLink) lifetime() Lifetime {
+	return LifetimeForever
+}
+
+func (link *funcLink) priority() int {
+	return 0
+}
+
+func (link *funcLink) tags() []string {
+	return nil
+}
+
+func (link *funcLink) deprecated() string {
+	return ""
+}
+
+func (link *funcLink) seeded() bool {
+	return false
+}
+
+func (link *funcLink) retries() (int, time.Duration) {
+	return 0, 0
+}
+
+func (link *funcLink) dependencies() ([]reflect.Type, bool) {
+	ctorType := link.ctor.Type()
+	types := make([]reflect.Type, ctorType.NumIn())
+	for i := range types {
+		types[i] = ctorType.In(i)
+	}
+	return types, true
+}
+
+func (link *funcLink) get(scope *Scope) (any, error) {
+	return scope.getOrCreate(link, link.key)
+}
+
+// Calls the constructor with arguments resolved from the scope and returns its first result,
+// or its second result if it's a non-nil error.
+func (link *funcLink) create(scope *Scope) (any, error) {
+	args, err := scope.resolveArgs(link.ctor.Type(), link.strict, nil)
+	if err != nil {
+		return nil, err
+	}
+	results := call(link.ctor, args)
+	if len(results) > 1 && !results[1].IsNil() {
+		return nil, results[1].Interface().(error)
+	}
+	return results[0].Interface(), nil
+}
+
+func (link *funcLink) beforePointerUse(scope *Scope, value any) error {
+	return nil
+}
+
+func (link *funcLink) afterPointerUse(scope *Scope, value any) error {
+	return nil
+}
+
+func (link *funcLink) reset(scope *Scope) (bool, error) {
+	return false, nil
+}
+
+func (link *funcLink) refresh(scope *Scope) (bool, error) {
+	return false, nil
+}
+
+func (link *funcLink) free(scope *Scope) error {
+	if _, exists := scope.removeInstance(link.key); exists {
+		scope.emit(Event{Kind: EventFreed, Type: link.key.typ})
+	}
+	return nil
+}
+
+// A provider registered with Alias which resolves its value from another type.
+type aliasLink struct {
+	key binding
+	to  binding
+}
+
+var _ link = &aliasLink{}
+
+// Aliases resolve the target from the requesting scope like scoped values, the target's
+// provider decides where the value is stored.
+func (link *aliasLink) lifetime() Lifetime {
+	return LifetimeScope
+}
+
+func (link *aliasLink) priority() int {
+	return 0
+}
+
+func (link *aliasLink) tags() []string {
+	return nil
+}
+
+func (link *aliasLink) deprecated() string {
+	return ""
+}
+
+func (link *aliasLink) seeded() bool {
+	return false
+}
+
+func (link *aliasLink) retries() (int, time.Duration) {
+	return 0, 0
+}
+
+func (link *aliasLink) dependencies() ([]reflect.Type, bool) {
+	return []reflect.Type{link.to.typ}, true
+}
+
+// Returns a pointer to the alias type set to the target value, or to the pointer to
+// the target value when only the pointer is assignable. The alias is added to the
+// resolution chain so aliases which lead back to themselves return ErrCircularDependency.
+// Aliases don't store their value so they're resolved as transient.
+func (link *aliasLink) get(scope *Scope) (any, error) {
+	resolving, err := scope.resolve(link.key, LifetimeTransient)
+	if err != nil {
+		return nil, err
+	}
+	target, err := resolving.get(link.to)
+	if err != nil {
+		return nil, err
+	}
+	targetValue := reflect.ValueOf(target)
+	if link.to.typ.AssignableTo(link.key.typ) {
+		targetValue = targetValue.Elem()
+	}
+	ptr := reflect.New(link.key.typ)
+	ptr.Elem().Set(targetValue)
+	return ptr.Interface(), nil
+}
+
+func (link *aliasLink) create(scope *Scope) (any, error) {
+	return link.get(scope)
+}
+
+func (link *aliasLink) beforePointerUse(scope *Scope, value any) error {
+	return nil
+}
+
+func (link *aliasLink) afterPointerUse(scope *Scope, value any) error {
+	return nil
+}
+
+func (link *aliasLink) reset(scope *Scope) (bool, error) {
+	return false, nil
+}
+
+func (link *aliasLink) refresh(scope *Scope) (bool, error) {
+	return false, nil
+}
+
+func (link *aliasLink) free(scope *Scope) error {
+	return nil
+}
+
+type Provider[V any] struct {
+	Lifetime         Lifetime
+	Create           func(scope *Scope) (*V, error)
+	BeforePointerUse func(scope *Scope, value *V) error
+	AfterPointerUse  func(scope *Scope, value *V) error
+	Free             func(scope *Scope, value *V) error
+
+	// How long Create can take before it's abandoned, zero means there is no limit.
+	Timeout time.Duration
+	// Which provider is preferred when several could provide a value, higher is preferred.
+	// Only interface resolution and group ordering use it, a type with a single provider
+	// ignores it.
+	Priority int
+	// Labels for values created by this provider so they can be freed with FreeTagged.
+	Tags []string
+	// Clears the state of a value with a lifetime of once so it can be reused. When set FreeOnce
+	// calls it instead of Free and the value stays in the scope.
+	Reset func(scope *Scope, value *V) error
+	// If ProvideChecked should accept a nil Create because the value is set on the scope later.
+	AllowMissingCreate bool
+	// Why the provider is deprecated. When set an EventDeprecated with the message is sent the
+	// first time a scope creates a value with the provider.
+	Deprecated string
+	// How many more times Create is called when it returns an error before the error is
+	// returned, zero means it's only called once. Retrying stops once the context of the
+	// scope creating the value is done.
+	Retries int
+	// How long to wait before calling Create again after it returns an error.
+	RetryDelay time.Duration
+	// Re-evaluates a created value when scope.Refresh is called. It returns the value to use
+	// and whether it changed, a changed value replaces the current one which is freed if it's
+	// a different value.
+	Refresh func(scope *Scope, current *V) (*V, bool, error)
+	// Copies the value of a parent scope to create the value of a scope. When set and a parent
+	// of the scope has a value it's used as the template instead of calling Create.
+	Clone func(scope *Scope, template *V) (*V, error)
+}
+
+type Scope struct {
 	Dynamic DynamicProvider
+	// If unexported struct fields should be hydrated. By default only exported fields are set.
+	HydrateUnexported bool
+	// If an interface type without a provider should be resolved by the only provider whose
+	// type implements the interface, or the one with the highest priority.
+	ResolveByInterface bool
+	// If nil slices should be hydrated with a single element when the element type can be
+	// resolved. By default nil slices are left as is.
+	HydrateNilSlices bool
+	// How deep hydration can go into nested values before it stops with ErrHydrateDepthExceeded.
+	// Zero uses DefaultHydrateMaxDepth.
+	HydrateMaxDepth int
+	// Called when a value is created, reused, or freed in this scope or any of its children.
+	OnEvent func(Event)
+	// Returns the identity of a type. When a type has no provider or value the unnamed provider
+	// or value available to this scope with the same identity is used instead, as long as a
+	// pointer to its type can be converted to a pointer to the requested type. By default types
+	// are only resolved by their exact type. Identities must be comparable, and distinct types
+	// given the same identity share a value, so only give types the same identity when they
+	// have the same underlying type and meaning.
+	KeyFunc func(reflect.Type) any
+	// If functions invoked on this scope should not be called when a pointer argument can't be
+	// resolved. Instead an error wrapping ErrNoProvider is returned listing the arguments, since
+	// a nil pointer is likely a wiring mistake. Overridden arguments can be nil.
+	RejectNilPointers bool
+	// If registering a provider for a type which already has a value on this scope frees the
+	// value so the new provider creates it the next time it's requested. By default the value
+	// stays until the scope is freed. Values on child scopes are not freed.
+	FreeReplaced bool
+
+	*state
+	parent    *Scope
+	resolving *resolution
+	ctx       context.Context
+	recording *recorder
+}
+
+// The state of a scope shared between the scope and any views of it created while
+// resolving values.
+type state struct {
+	mutex        sync.RWMutex
+	providers    map[binding]link
+	instances    map[binding]any
+	creators     map[binding]link
+	order        []binding
+	groups       map[reflect.Type][]binding
+	decorators   map[reflect.Type][]decorator
+	required     map[reflect.Type]struct{}
+	defaults     map[reflect.Type]any
+	children     map[weak.Pointer[Scope]]struct{}
+	dynamics     []DynamicProvider
+	middlewares  []Middleware
+	deprecations map[binding]struct{}
+	creating     map[binding]*sync.Mutex
+	frozen       atomic.Bool
+	logger       atomic.Pointer[func(format string, args ...any)]
+	inherits     *state
+	freeOnGC     atomic.Bool
+}
+
+// Returns a copy of the state.
+func (s *state) clone() *state {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	clone := &state{
+		providers:    make(map[binding]link, len(s.providers)),
+		instances:    make(map[binding]any, len(s.instances)),
+		creators:     make(map[binding]link, len(s.creators)),
+		order:        make([]binding, len(s.order)),
+		groups:       make(map[reflect.Type][]binding, len(s.groups)),
+		decorators:   make(map[reflect.Type][]decorator, len(s.decorators)),
+		required:     make(map[reflect.Type]struct{}, len(s.required)),
+		defaults:     make(map[reflect.Type]any, len(s.defaults)),
+		children:     make(map[weak.Pointer[Scope]]struct{}),
+		deprecations: make(map[binding]struct{}, len(s.deprecations)),
+		creating:     make(map[binding]*sync.Mutex),
+	}
+	for key, link := range s.providers {
+		clone.providers[key] = link
+	}
+	for key, instance := range s.instances {
+		clone.instances[key] = instance
+	}
+	for key, creator := range s.creators {
+		clone.creators[key] = creator
+	}
+	copy(clone.order, s.order)
+	for typ, members := range s.groups {
+		clone.groups[typ] = append([]binding{}, members...)
+	}
+	for typ, decorators := range s.decorators {
+		clone.decorators[typ] = append([]decorator{}, decorators...)
+	}
+	for typ := range s.required {
+		clone.required[typ] = struct{}{}
+	}
+	for typ, value := range s.defaults {
+		clone.defaults[typ] = value
+	}
+	clone.dynamics = append([]DynamicProvider{}, s.dynamics...)
+	clone.middlewares = append([]Middleware{}, s.middlewares...)
+	clone.logger.Store(s.logger.Load())
+	clone.inherits = s.inherits
+	for key := range s.deprecations {
+		clone.deprecations[key] = struct{}{}
+	}
+	return clone
+}
+
+// The registrations and values of a scope at a point in time, see Scope.Snapshot.
+type Snapshot struct {
+	state *state
+}
+
+// Returns a snapshot of the providers, groups, decorators, defaults, required types, dynamic
+// providers, and values on this scope which can be restored later with Restore.
+func (scope *Scope) Snapshot() Snapshot {
+	return Snapshot{state: scope.state.clone()}
+}
+
+// Restores this scope to the given snapshot. Values stored since the snapshot are freed in
+// the reverse order they were created, and providers, groups, decorators, defaults, required
+// types, and dynamic providers are put back to what they were. This is useful for rolling back wiring
+// when setup fails part way through. A frozen scope can't be restored and an error wrapping
+// ErrScopeFrozen is returned.
+func (scope *Scope) Restore(snapshot Snapshot) error {
+	if err := scope.checkFrozen(TypeOf[Snapshot]()); err != nil {
+		return err
+	}
+	multi := multiError{}
+	keys := scope.instanceKeys()
+	for i := len(keys) - 1; i >= 0; i-- {
+		key := keys[i]
+		if _, existed := snapshot.state.instances[key]; existed {
+			continue
+		}
+		if link := scope.instanceLink(key); link != nil {
+			err := link.free(scope)
+			if err != nil {
+				multi.errors = append(multi.errors, err)
+			}
+		} else {
+			scope.removeInstance(key)
+		}
+	}
+
+	restored := snapshot.state.clone()
+	scope.mutex.Lock()
+	scope.providers = restored.providers
+	scope.groups = restored.groups
+	scope.decorators = restored.decorators
+	scope.required = restored.required
+	scope.defaults = restored.defaults
+	scope.dynamics = restored.dynamics
+	scope.middlewares = restored.middlewares
+	scope.mutex.Unlock()
+
+	if len(multi.errors) > 0 {
+		return multi
+	}
+	return nil
+}
+
+// A function which augments or replaces a value created by a provider.
+type decorator func(scope *Scope, value any) (any, error)
+
+// A function which wraps the creation of every value on a scope. It's given a function which
+// creates the value and the type of the value, and returns the value to use.
+type Middleware func(next func() (any, error), key reflect.Type) (any, error)
+
+// The key of values and providers in a scope, a type and an optional name or group member.
+type binding struct {
+	typ    reflect.Type
+	name   string
+	member uint64
+}
+
+// The last group member given to a provider, members are unique across all scopes.
+var members uint64
+
+func (b binding) String() string {
+	if b.member != 0 {
+		return fmt.Sprintf("%s #%d", b.typ, b.member)
+	}
+	if b.name == "" {
+		return b.typ.String()
+	}
+	return fmt.Sprintf("%s %q", b.typ, b.name)
+}
+
+// Records the bindings requested from a scope instead of resolving them.
+type recorder struct {
+	mutex sync.Mutex
+	keys  []binding
+}
+
+func (r *recorder) record(key binding) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, recorded := range r.keys {
+		if recorded == key {
+			return
+		}
+	}
+	r.keys = append(r.keys, key)
+}
+
+// A chain of types currently being created, the most recent type is first.
+type resolution struct {
+	key      binding
+	lifetime Lifetime
+	previous *resolution
+}
+
+// Creates a new scope with the global scope as the parent.
+func New() *Scope {
+	return new(Global())
+}
+
+func new(parent *Scope) *Scope {
+	return &Scope{
+		parent: parent,
+		state: &state{
+			providers:    make(map[binding]link),
+			instances:    make(map[binding]any),
+			creators:     make(map[binding]link),
+			groups:       make(map[reflect.Type][]binding),
+			decorators:   make(map[reflect.Type][]decorator),
+			required:     make(map[reflect.Type]struct{}),
+			defaults:     make(map[reflect.Type]any),
+			children:     make(map[weak.Pointer[Scope]]struct{}),
+			deprecations: make(map[binding]struct{}),
+			creating:     make(map[binding]*sync.Mutex),
+		},
+	}
+}
+
+// Returns a copy of this scope with the same parent. The providers and values of this scope
+// are copied to the clone so values set or created in the clone don't affect this scope,
+// however the values themselves are shared until they're replaced. Since values are shared
+// only the clone or this scope should free them. Parents are never copied, so cloning a
+// child of the global scope references the same global scope, and cloning the global scope
+// returns a new child of the global scope.
+func (scope *Scope) Clone() *Scope {
+	if scope.state == Global().state {
+		return New()
+	}
+	clone := *scope
+	clone.state = scope.state.clone()
+	clone.resolving = nil
+	clone.ctx = nil
+	clone.recording = nil
+	return &clone
+}
+
+// Returns a copy of this scope like Clone except values with a lifetime of scope are
+// created again for the clone, so the clone has its own instances of them.
+func (scope *Scope) DeepClone() (*Scope, error) {
+	clone := scope.Clone()
+	multi := multiError{}
+	for _, key := range clone.instanceKeys() {
+		if link := clone.instanceLink(key); link != nil && link.lifetime() == LifetimeScope {
+			clone.removeInstance(key)
+			_, err := link.get(clone)
+			if err != nil {
+				multi.errors = append(multi.errors, err)
+			}
+		}
+	}
+	if len(multi.errors) > 0 {
+		return clone, multi
+	}
+	return clone, nil
+}
+
+// A bundle of providers and values which is installed on a scope. Libraries can export a
+// module which wires their subsystem.
+type Module func(scope *Scope) error
+
+// Installs the modules on this scope in order. Every module is installed and any errors
+// they return are returned together.
+func (scope *Scope) Install(modules ...Module) error {
+	multi := multiError{}
+	for _, module := range modules {
+		if err := module(scope); err != nil {
+			multi.errors = append(multi.errors, err)
+		}
+	}
+	if len(multi.errors) > 0 {
+		return multi
+	}
+	return nil
+}
+
+// Returns a readable snapshot of this scope and its parents listing each type with a provider
+// and its lifetime or a value, and whether a Dynamic is set. Each parent is indented under its
+// child. No values are created.
+//
+//	scope
+//	  deps.Cache: scope provider, value
+//	  deps.Config: value
+//	  parent
+//	    deps.DB: forever provider
+func (scope *Scope) String() string {
+	out := strings.Builder{}
+	indent := ""
+	for curr := scope; curr != nil; curr = curr.parent {
+		if curr == scope {
+			out.WriteString("scope\n")
+		} else {
+			out.WriteString(indent + "parent\n")
+		}
+		indent += "  "
+
+		curr.mutex.RLock()
+		keys := make([]binding, 0, len(curr.providers)+len(curr.instances))
+		for key := range curr.providers {
+			keys = append(keys, key)
+		}
+		for key := range curr.instances {
+			if _, provided := curr.providers[key]; !provided {
+				keys = append(keys, key)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		for _, key := range keys {
+			details := []string{}
+			if link, exists := curr.providers[key]; exists {
+				details = append(details, link.lifetime().String()+" provider")
+			}
+			if _, exists := curr.instances[key]; exists {
+				details = append(details, "value")
+			}
+			out.WriteString(fmt.Sprintf("%s%s: %s\n", indent, key, strings.Join(details, ", ")))
+		}
+		curr.mutex.RUnlock()
+
+		if curr.localDynamic() != nil {
+			out.WriteString(indent + "dynamic\n")
+		}
+	}
+	return out.String()
+}
+
+// Returns a pointer to the value of the given type already created or set on this scope or
+// its parents, or false if there isn't one yet. Unlike Get this never calls providers or
+// dynamic providers, which makes it useful for inspecting values without creating them.
+func (scope *Scope) Peek(key reflect.Type) (any, bool) {
+	typeKey := binding{typ: key}
+	for curr := scope; curr != nil; curr = curr.parent {
+		if instance, exists := curr.getInstance(typeKey); exists {
+			return instance, true
+		}
+	}
+	return nil, false
+}
+
+// Returns the scope holding the value of the given type, which is this scope or one of its
+// parents. If the value hasn't been created yet the nearest scope with a provider for it is
+// returned, and if there is neither false is returned. No values are created.
+func (scope *Scope) Locate(key reflect.Type) (*Scope, bool) {
+	typeKey := binding{typ: key}
+	for curr := scope; curr != nil; curr = curr.parent {
+		if _, exists := curr.getInstance(typeKey); exists {
+			return curr, true
+		}
+	}
+	for curr := scope; curr != nil; curr = curr.parent {
+		curr.mutex.RLock()
+		_, exists := curr.providers[typeKey]
+		curr.mutex.RUnlock()
+		if exists {
+			return curr, true
+		}
+	}
+	return nil, false
+}
+
+// Returns this scope's parent.
+func (scope *Scope) Parent() *Scope {
+	return scope.parent
+}
+
+// Returns the context given to InvokeContext while the invoke is running, otherwise
+// context.Background() is returned.
+func (scope *Scope) Context() context.Context {
+	if scope.ctx == nil {
+		return context.Background()
+	}
+	return scope.ctx
+}
+
+// Returns a child to this scope like Spawn which also uses the values on this scope as its own
+// until it creates or sets its own value of the type. This avoids creating scoped values again
+// in children which only read them. Values created on this scope after the child is spawned
+// are used as well. Types the child has its own provider for aren't inherited, and the child
+// never frees values it inherits.
+func (scope *Scope) SpawnInheriting() *Scope {
+	child := scope.Spawn()
+	child.inherits = scope.state
+	return child
+}
+
+// Returns the value this scope inherits from the scope it was spawned from for the given type.
+// Nothing is inherited for types this scope has its own provider for.
+func (scope *Scope) getInherited(key binding) (any, bool) {
+	if scope.inherits == nil {
+		return nil, false
+	}
+	scope.mutex.RLock()
+	_, provided := scope.providers[key]
+	scope.mutex.RUnlock()
+	if provided {
+		return nil, false
+	}
+	scope.inherits.mutex.RLock()
+	defer scope.inherits.mutex.RUnlock()
+	instance, exists := scope.inherits.instances[key]
+	return instance, exists
+}
+
+// Changes the parent of this scope, or makes it a root scope when parent is nil. If the parent
+// is this scope or one of its children an error wrapping ErrParentCycle is returned instead,
+// which keeps every walk up the parents finite. A spawned scope is tracked by its new parent
+// for FreeTree. This must not be called while values are resolved from the scope.
+func (scope *Scope) SetParent(parent *Scope) error {
+	for s := parent; s != nil; s = s.parent {
+		if s.state == scope.state {
+			return ErrParentCycle
+		}
+	}
+	previous := scope.parent
+	scope.parent = parent
+	if previous == nil {
+		return nil
+	}
+	ref := weak.Make(scope)
+	previous.mutex.Lock()
+	_, spawned := previous.children[ref]
+	delete(previous.children, ref)
+	previous.mutex.Unlock()
+	if spawned && parent != nil {
+		parent.track(scope)
+	}
+	return nil
+}
+
+// Returns a child to this scope. The child is tracked by this scope so FreeTree can free it,
+// but it's forgotten once it's garbage collected.
+func (scope *Scope) Spawn() *Scope {
+	child := new(scope)
+	scope.track(child)
+	return child
+}
+
+// Tracks the child for FreeTree with a weak pointer so the child can still be garbage
+// collected, and forgets it once it is.
+func (scope *Scope) track(child *Scope) {
+	ref := weak.Make(child)
+	scope.mutex.Lock()
+	scope.children[ref] = struct{}{}
+	scope.mutex.Unlock()
+	parent := scope.state
+	runtime.AddCleanup(child, func(ref weak.Pointer[Scope]) {
+		parent.mutex.Lock()
+		delete(parent.children, ref)
+		parent.mutex.Unlock()
+	}, ref)
+}
+
+// Frees the values of this scope when it's garbage collected, as a safety net for scopes
+// which may not be freed explicitly. This is best-effort and non-deterministic: the Go runtime
+// doesn't guarantee when or if a scope is collected, values which reference the scope keep it
+// from being collected, and errors returned by Free are discarded. The scope is kept alive
+// while values are resolved from it, but values which keep the scope given to their provider,
+// like a Lazy handle, don't keep it alive and shouldn't be used once the scope is dropped.
+// Values already freed with Free are not freed again, and values are freed with the settings
+// the scope has when this is called. This must be called on a scope returned by New, Spawn, or
+// Clone and not on the scope given to a provider.
+func (scope *Scope) FreeOnGC() {
+	if scope.freeOnGC.CompareAndSwap(false, true) {
+		runtime.AddCleanup(scope, func(view *Scope) {
+			view.Free()
+		}, scope.view())
+	}
+}
+
+// Sets a value on this scope. The value is stored under its dynamic type, or the type it
+// points to when it's a pointer, so setting a *DB is retrieved with Get[DB]. Since the dynamic
+// type is used a value held in an interface is stored under its concrete type, use SetAs to
+// store it under the interface. A nil value or nil pointer returns ErrNilValue.
+func (scope *Scope) Set(value any) error {
+	if IsNil(value) {
+		return ErrNilValue
+	}
+	key, ptr := pointerOf(value)
+	if err := scope.checkFrozen(key); err != nil {
+		return err
+	}
+	scope.setInstance(binding{typ: key}, ptr)
+	return nil
+}
+
+// Sets a default value on this scope. A default value is only used for invoked function
+// arguments when there is no value or provider for the type in this scope or its parents.
+// Defaults on this scope take priority over defaults on parent scopes. An error wrapping
+// ErrScopeFrozen is returned if this scope is frozen.
+func (scope *Scope) SetDefault(value any) error {
+	key, ptr := pointerOf(value)
+	if err := scope.checkFrozen(key); err != nil {
+		return err
+	}
+	scope.mutex.Lock()
+	defer scope.mutex.Unlock()
+	scope.defaults[key] = ptr
+	return nil
+}
+
+// Returns the default value for the given type on this scope or its parents.
+func (scope *Scope) getDefault(key reflect.Type) (any, bool) {
+	scope.mutex.RLock()
+	value, exists := scope.defaults[key]
+	scope.mutex.RUnlock()
+	if !exists && scope.parent != nil {
+		return scope.parent.getDefault(key)
+	}
+	return value, exists
+}
+
+// Returns the type of the value and a pointer to it. If the value is a pointer its
+// element type is returned with the value, otherwise a pointer to a copy of the value
+// is returned.
+func pointerOf(value any) (reflect.Type, any) {
+	key := reflect.TypeOf(value)
+	if key.Kind() != reflect.Pointer {
+		ptr := reflect.New(key)
+		ptr.Elem().Set(reflect.ValueOf(value))
+		return key, ptr.Interface()
+	}
+	return key.Elem(), value
+}
+
+// Gets a value from this scope with the given type and potentially returns an error.
+// If it doesn't exist on this scope a provider is searched through the parent scopes.
+// If the provider has a lifetime of forever its created on the deepest scope, otherwise
+// scope and once lifetime values are stored in this scope. The returned value is always
+// a pointer to the given type. Getting Scope returns the scope itself, so invoked functions
+// and constructors can accept the *Scope resolving them.
+func (scope *Scope) Get(key reflect.Type) (any, error) {
+	defer runtime.KeepAlive(scope)
+	return scope.get(binding{typ: key})
+}
+
+// Gets a value for each of the given types from this scope. The returned values are in the
+// same order as the types with nil for any type which failed to resolve. All errors are
+// returned together while the values which were resolved are still returned.
+func (scope *Scope) GetAll(keys ...reflect.Type) ([]any, error) {
+	values := make([]any, len(keys))
+	multi := multiError{}
+	for i, key := range keys {
+		value, err := scope.Get(key)
+		if err != nil {
+			multi.errors = append(multi.errors, fmt.Errorf("%s: %w", key, err))
+			continue
+		}
+		values[i] = value
+	}
+	if len(multi.errors) > 0 {
+		return values, multi
+	}
+	return values, nil
+}
+
+// Gets a value from this scope with the given binding. Named bindings are only resolved
+// through providers and never dynamically.
+func (scope *Scope) get(key binding) (any, error) {
+	if key == (binding{typ: scopeType}) {
+		return scope, nil
+	}
+	if scope.recording != nil {
+		scope.recording.record(key)
+		return reflect.New(key.typ).Interface(), nil
+	}
+	if scope.ctx != nil && key == (binding{typ: contextType}) {
+		return &scope.ctx, nil
+	}
+	if err := scope.checkLifetime(key); err != nil {
+		return nil, err
+	}
+	if instance, exists := scope.getInstance(key); exists {
+		scope.trace(key, "using value")
+		scope.emit(Event{Kind: EventReused, Type: key.typ})
+		return instance, nil
+	}
+	if instance, exists := scope.getInherited(key); exists {
+		scope.trace(key, "using inherited value")
+		scope.emit(Event{Kind: EventReused, Type: key.typ})
+		return instance, nil
+	}
+	deepLink := scope.getLink(key)
+	if deepLink != nil {
+		switch deepLink.lifetime() {
+		case LifetimeScope, LifetimeContext, LifetimeTransient:
+			scope.trace(key, "using %s provider", deepLink.lifetime())
+			return deepLink.get(scope)
+		case LifetimeSingleton:
+			scope.trace(key, "using singleton provider on global")
+			return deepLink.get(scope.on(Global()))
+		}
+	}
+	scope.mutex.RLock()
+	link := scope.providers[key]
+	scope.mutex.RUnlock()
+	if link == nil && key.name == "" && key.member == 0 {
+		dynamic := GetDynamic(key.typ)
+		if dynamic != nil {
+			err := dynamic.ProvideDynamic(scope)
+			if err != nil {
+				return nil, err
+			}
+			if ptr, ok := pointerTo(key.typ, dynamic); ok {
+				scope.trace(key, "using Dynamic")
+				return ptr, nil
+			}
+		}
+		if dynamic := scope.dynamicProvider(key); dynamic != nil {
+			dyn, err := dynamic(key.typ, scope)
+			if err != nil {
+				return nil, err
+			}
+			if ptr, ok := pointerTo(key.typ, dyn); ok {
+				scope.trace(key, "using dynamic provider")
+				return ptr, nil
+			}
+		}
+	}
+	if link == nil {
+		if scope.parent != nil {
+			scope.trace(key, "miss local, checking parent")
+			par, err := scope.up().get(key)
+			if err == nil || err != ErrNoProvider {
+				return par, err
+			}
+		}
+		if scope.ResolveByInterface && key.typ.Kind() == reflect.Interface && key.name == "" && key.member == 0 {
+			scope.trace(key, "using implementation")
+			return scope.getImplementation(key.typ)
+		}
+		if scope.KeyFunc != nil && key.name == "" && key.member == 0 {
+			scope.trace(key, "using KeyFunc")
+			return scope.getKeyed(key.typ)
+		}
+		scope.trace(key, "no provider")
+		return nil, ErrNoProvider
+	}
+	scope.trace(key, "using %s provider", link.lifetime())
+	return link.get(scope)
+}
+
+// Returns the DynamicProvider for the given binding, which is the dynamic providers of this
+// scope or the first parent with dynamic providers. Parents with a value or provider for the binding take
+// precedence, so nil is returned when one is found before a Dynamic.
+func (scope *Scope) dynamicProvider(key binding) DynamicProvider {
+	if dynamic := scope.localDynamic(); dynamic != nil {
+		return dynamic
+	}
+	for s := scope.parent; s != nil; s = s.parent {
+		s.mutex.RLock()
+		_, hasInstance := s.instances[key]
+		_, hasProvider := s.providers[key]
+		s.mutex.RUnlock()
+		if hasInstance || hasProvider {
+			return nil
+		}
+		if dynamic := s.localDynamic(); dynamic != nil {
+			return dynamic
+		}
+	}
+	return nil
+}
+
+// Adds a DynamicProvider to this scope which is tried after Dynamic and the dynamic providers
+// added before it, until one returns a value for the requested type.
+func (scope *Scope) AddDynamic(dynamic DynamicProvider) {
+	scope.mustNotBeFrozen(TypeOf[DynamicProvider]())
+	scope.mutex.Lock()
+	defer scope.mutex.Unlock()
+	scope.dynamics = append(scope.dynamics, dynamic)
+}
+
+// Returns a DynamicProvider which tries Dynamic and then the dynamic providers added with
+// AddDynamic in order until one returns a value for the type, or nil if there are none.
+func (scope *Scope) localDynamic() DynamicProvider {
+	scope.mutex.RLock()
+	dynamics := scope.dynamics
+	scope.mutex.RUnlock()
+	if len(dynamics) == 0 {
+		return scope.Dynamic
+	}
+	if scope.Dynamic != nil {
+		dynamics = append([]DynamicProvider{scope.Dynamic}, dynamics...)
+	}
+	return func(typ reflect.Type, scope *Scope) (any, error) {
+		for _, dynamic := range dynamics {
+			value, err := dynamic(typ, scope)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := pointerTo(typ, value); ok {
+				return value, nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+// Returns a pointer to the given interface type set to the value of the only provider
+// available to this scope whose type, or pointer to its type, implements the interface.
+// When several providers implement it the one with the highest priority is used.
+// If there are no providers ErrNoProvider is returned and if there are multiple an error
+// wrapping ErrAmbiguousProvider is returned.
+func (scope *Scope) getImplementation(iface reflect.Type) (any, error) {
+	candidates := scope.implementations(iface)
+	if len(candidates) == 0 {
+		return nil, ErrNoProvider
+	}
+	if len(candidates) > 1 {
+		types := make([]string, len(candidates))
+		for i, candidate := range candidates {
+			types[i] = candidate.String()
+		}
+		return nil, fmt.Errorf("%w: %s is implemented by %s", ErrAmbiguousProvider, iface, strings.Join(types, ", "))
+	}
+	instance, err := scope.get(candidates[0])
+	if err != nil {
+		return nil, err
+	}
+	value := reflect.ValueOf(instance)
+	if !value.Type().Implements(iface) {
+		value = value.Elem()
+	}
+	ptr := reflect.New(iface)
+	ptr.Elem().Set(value)
+	return ptr.Interface(), nil
+}
+
+// Returns a pointer to the given type which points to the value of the only provider or
+// value available to this scope with the same identity according to KeyFunc. If there are
+// none ErrNoProvider is returned and if there are multiple an error wrapping
+// ErrAmbiguousProvider is returned.
+func (scope *Scope) getKeyed(typ reflect.Type) (any, error) {
+	candidates := scope.keyed(typ)
+	if len(candidates) == 0 {
+		return nil, ErrNoProvider
+	}
+	if len(candidates) > 1 {
+		types := make([]string, len(candidates))
+		for i, candidate := range candidates {
+			types[i] = candidate.String()
+		}
+		return nil, fmt.Errorf("%w: %s has the same key as %s", ErrAmbiguousProvider, typ, strings.Join(types, ", "))
+	}
+	instance, err := scope.get(candidates[0])
+	if err != nil {
+		return nil, err
+	}
+	return reflect.ValueOf(instance).Convert(reflect.PointerTo(typ)).Interface(), nil
+}
+
+// Returns the unnamed bindings of providers and values available to this scope which have
+// the same identity as the given type according to KeyFunc and can be converted to it.
+func (scope *Scope) keyed(typ reflect.Type) []binding {
+	id := scope.KeyFunc(typ)
+	ptr := reflect.PointerTo(typ)
+	candidates := []binding{}
+	seen := make(map[binding]bool)
+	consider := func(key binding) {
+		if seen[key] || key.typ == typ || key.name != "" || key.member != 0 {
+			return
+		}
+		seen[key] = true
+		if reflect.PointerTo(key.typ).ConvertibleTo(ptr) && scope.KeyFunc(key.typ) == id {
+			candidates = append(candidates, key)
+		}
+	}
+	for curr := scope; curr != nil; curr = curr.parent {
+		curr.mutex.RLock()
+		for key := range curr.instances {
+			consider(key)
+		}
+		for key := range curr.providers {
+			consider(key)
+		}
+		curr.mutex.RUnlock()
+	}
+	return candidates
+}
+
+// Returns the bindings of unnamed providers available to this scope whose type, or pointer
+// to its type, implements the given interface. Only the providers with the highest priority
+// are returned and providers on this scope are first.
+func (scope *Scope) implementations(iface reflect.Type) []binding {
+	candidates := []binding{}
+	highest := 0
+	seen := make(map[binding]bool)
+	for curr := scope; curr != nil; curr = curr.parent {
+		curr.mutex.RLock()
+		for key, link := range curr.providers {
+			if seen[key] || key.name != "" || key.member != 0 {
+				continue
+			}
+			seen[key] = true
+			if !key.typ.Implements(iface) && !reflect.PointerTo(key.typ).Implements(iface) {
+				continue
+			}
+			priority := link.priority()
+			if len(candidates) == 0 || priority > highest {
+				candidates = candidates[:0]
+				highest = priority
+			}
+			if priority == highest {
+				candidates = append(candidates, key)
+			}
+		}
+		curr.mutex.RUnlock()
+	}
+	return candidates
+}
+
+// Applies the decorators of the given type to the value starting with the furthest parent.
+func (scope *Scope) decorate(typ reflect.Type, value any) (any, error) {
+	var err error
+	if scope.parent != nil {
+		value, err = scope.up().decorate(typ, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	scope.mutex.RLock()
+	decorators := scope.decorators[typ]
+	scope.mutex.RUnlock()
+	for _, decorate := range decorators {
+		value, err = decorate(scope, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// Returns whether a value of the given type can be resolved from this scope without creating
+// it. The type can be resolved if it has a value or provider in this scope or its parents,
+// the type implements Dynamic, a slice type has group members, or there is a Dynamic func on
+// this scope or its parents. Dynamic funcs aren't called since that could create values, so
+// they're assumed to support every type.
+func (scope *Scope) Has(key reflect.Type) bool {
+	if key.Kind() == reflect.Slice && len(scope.groupMembers(key.Elem())) > 0 {
+		return true
+	}
+	return scope.has(binding{typ: key})
+}
+
+// Returns whether the binding can be resolved from this scope without creating it, counting
+// a Dynamic func available to the scope as resolving every type.
+func (scope *Scope) has(key binding) bool {
+	return scope.provides(key, true)
+}
+
+// Returns whether the binding can be resolved from this scope without creating it. Dynamic
+// funcs are never called, when dynamic is true having one counts as resolving the binding.
+// Hydration doesn't count them so it doesn't allocate values a Dynamic func may not support.
+func (scope *Scope) provides(key binding, dynamic bool) bool {
+	if key == (binding{typ: scopeType}) {
+		return true
+	}
+	if scope.ctx != nil && key == (binding{typ: contextType}) {
+		return true
+	}
+	if _, exists := scope.getInstance(key); exists {
+		return true
+	}
+	if scope.getLink(key) != nil {
+		return true
+	}
+	if key.name == "" && key.member == 0 {
+		if isDynamic(key.typ) {
+			return true
+		}
+		if dynamic && scope.dynamicProvider(key) != nil {
+			return true
+		}
+	}
+	if scope.parent != nil && scope.up().provides(key, dynamic) {
+		return true
+	}
+	return scope.KeyFunc != nil && key.name == "" && key.member == 0 && len(scope.keyed(key.typ)) == 1
+}
+
+// Returns pointers to the values of all members in the group of the given type from this
+// scope and its parents.
+func (scope *Scope) getGroup(typ reflect.Type) ([]any, error) {
+	members := scope.groupMembers(typ)
+	priorities := make(map[binding]int, len(members))
+	for _, member := range members {
+		if link := scope.getLink(member); link != nil {
+			priorities[member] = link.priority()
+		}
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		return priorities[members[i]] > priorities[members[j]]
+	})
+	instances := make([]any, len(members))
+	for i, member := range members {
+		instance, err := scope.get(member)
+		if err != nil {
+			return nil, err
+		}
+		instances[i] = instance
+	}
+	return instances, nil
+}
+
+// Returns the bindings of all members in the group of the given type from this scope and its
+// parents, starting with the furthest parent.
+func (scope *Scope) groupMembers(typ reflect.Type) []binding {
+	members := []binding{}
+	if scope.parent != nil {
+		members = scope.parent.groupMembers(typ)
+	}
+	scope.mutex.RLock()
+	defer scope.mutex.RUnlock()
+	return append(members, scope.groups[typ]...)
+}
+
+// Converts a dynamically provided value into a pointer to the given type. If the value
+// is already a pointer to the type it's returned as is, if it's assignable to the type
+// a pointer is allocated for it, otherwise false is returned.
+func pointerTo(key reflect.Type, value any) (any, bool) {
+	if value == nil {
+		return nil, false
+	}
+	typ := reflect.TypeOf(value)
+	if typ == reflect.PointerTo(key) {
+		return value, true
+	}
+	if typ.AssignableTo(key) {
+		ptr := reflect.New(key)
+		ptr.Elem().Set(reflect.ValueOf(value))
+		return ptr.Interface(), true
+	}
+	return nil, false
+}
+
+// Returns the instance of the given binding on this scope, creating it with the link if it doesn't
+// exist yet. Creation is guarded by a mutex per scope and binding so concurrent requests for the
+// same value only call create once while other scopes create their own values in parallel, and
+// create is given a view of the scope which tracks the chain of types being created so circular
+// dependencies are returned as errors. Created values are decorated before they're stored, and
+// the link is remembered so it can free the value.
+func (scope *Scope) getOrCreate(creator link, key binding) (any, error) {
+	transient := creator.lifetime() == LifetimeTransient
+	if value, exists := scope.getInstance(key); exists && !transient {
+		return value, nil
+	}
+	resolving, err := scope.resolve(key, creator.lifetime())
+	if err != nil {
+		return nil, err
+	}
+	if transient {
+		return scope.createValue(creator, key, resolving)
+	}
+	creating := scope.creatingMutex(key)
+	creating.Lock()
+	defer creating.Unlock()
+	if value, exists := scope.getInstance(key); exists {
+		return value, nil
+	}
+	created, err := scope.createValue(creator, key, resolving)
+	if err != nil {
+		return nil, err
+	}
+	scope.storeInstance(key, created, creator)
+	if creator.lifetime() == LifetimeContext {
+		scope.freeOnDone(creator, key, created)
+	}
+	return created, nil
+}
+
+// Returns the mutex which guards creating the value of the given binding on this scope.
+func (scope *Scope) creatingMutex(key binding) *sync.Mutex {
+	scope.mutex.Lock()
+	defer scope.mutex.Unlock()
+	creating, exists := scope.creating[key]
+	if !exists {
+		creating = &sync.Mutex{}
+		scope.creating[key] = creating
+	}
+	return creating
+}
+
+// Adds middleware which wraps the creation of every value on this scope and its children, like
+// timing or tracing creation. Middleware on parents wraps middleware on children and
+// middleware on a scope wraps the middleware added after it.
+//
+//	scope.Use(func(next func() (any, error), key reflect.Type) (any, error) { ... })
+func (scope *Scope) Use(middleware Middleware) {
+	scope.mustNotBeFrozen(TypeOf[Middleware]())
+	scope.mutex.Lock()
+	defer scope.mutex.Unlock()
+	scope.middlewares = append(scope.middlewares, middleware)
+}
+
+// Calls the link's create wrapped by the middleware of this scope and its parents.
+func (scope *Scope) create(creator link, key binding, resolving *Scope) (any, error) {
+	next := func() (any, error) {
+		return retry(creator, key, resolving)
+	}
+	for s := scope; s != nil; s = s.parent {
+		s.mutex.RLock()
+		middlewares := s.middlewares
+		s.mutex.RUnlock()
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			middleware, inner := middlewares[i], next
+			next = func() (any, error) {
+				return middleware(inner, key.typ)
+			}
+		}
+	}
+	return next()
+}
+
+// Calls the link's create and calls it again when it returns an error, up to the link's
+// retries with its delay between attempts. Retrying stops once the context of the resolving
+// scope is done. If more than one attempt fails the last error is returned with the number
+// of attempts.
+func retry(creator link, key binding, resolving *Scope) (any, error) {
+	retries, delay := creator.retries()
+	value, err := creator.create(resolving)
+	attempts := 1
+	for err != nil && attempts <= retries && sleep(resolving.Context(), delay) {
+		value, err = creator.create(resolving)
+		attempts++
+	}
+	if err != nil && attempts > 1 {
+		return nil, fmt.Errorf("%w: %s failed after %d attempts", err, key, attempts)
+	}
+	return value, err
+}
+
+// Waits for the given duration and returns true, or returns false as soon as the context is done.
+func sleep(ctx context.Context, duration time.Duration) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Creates a decorated value with the link in the resolution chain without storing it on
+// this scope.
+func (scope *Scope) createValue(creator link, key binding, resolving *Scope) (any, error) {
+	var start time.Time
+	if scope.observed() {
+		start = time.Now()
+	}
+	created, err := scope.create(creator, key, resolving)
+	if err != nil {
+		return nil, &CreateError{Type: key.typ, Depth: scope.depth(), Err: err}
+	}
+	decorated, err := resolving.decorate(key.typ, created)
+	if err != nil {
+		return nil, err
+	}
+	if !start.IsZero() {
+		scope.emit(Event{Kind: EventCreated, Type: key.typ, Elapsed: time.Since(start)})
+	}
+	if message := creator.deprecated(); message != "" && scope.warnDeprecated(key) {
+		scope.emit(Event{Kind: EventDeprecated, Type: key.typ, Message: message})
+	}
+	return decorated, nil
+}
+
+// Frees the value created by the link when the context of this scope is done, unless the
+// value was already freed.
+func (scope *Scope) freeOnDone(creator link, key binding, value any) {
+	if scope.ctx == nil || scope.ctx.Done() == nil {
+		return
+	}
+	go func() {
+		<-scope.ctx.Done()
+		if current, exists := scope.getInstance(key); exists && current == value {
+			creator.free(scope)
+		}
+	}()
+}
+
+// Returns how many parents this scope has, the global scope has a depth of zero.
+func (scope *Scope) depth() int {
+	depth := 0
+	for s := scope.parent; s != nil; s = s.parent {
+		depth++
+	}
+	return depth
+}
+
+// Sets the function the steps of resolving values on this scope and its children are logged
+// with, which helps find out where a value is resolved from. Children use the logger of their
+// nearest parent with one unless they set their own. By default nothing is logged, and setting
+// nil goes back to using the logger of a parent.
+//
+//	scope.SetLogger(log.Printf)
+func (scope *Scope) SetLogger(logger func(format string, args ...any)) {
+	if logger == nil {
+		scope.logger.Store(nil)
+	} else {
+		scope.logger.Store(&logger)
+	}
+}
+
+// Logs a step of resolving the given binding with the logger of this scope or its nearest
+// parent with one.
+func (scope *Scope) trace(key binding, format string, args ...any) {
+	for s := scope; s != nil; s = s.parent {
+		if logger := s.logger.Load(); logger != nil {
+			(*logger)("resolving %s at depth %d: "+format, append([]any{key, scope.depth()}, args...)...)
+			return
+		}
+	}
+}
+
+// Returns whether this scope or any of its parents has an OnEvent callback.
+func (scope *Scope) observed() bool {
+	for s := scope; s != nil; s = s.parent {
+		if s.OnEvent != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Sends an event from this scope to the OnEvent callbacks of this scope and all of its parents.
+func (scope *Scope) emit(event Event) {
+	event.Scope = scope
+	for s := scope; s != nil; s = s.parent {
+		if s.OnEvent != nil {
+			s.OnEvent(event)
+		}
+	}
+}
+
+// Returns true the first time it's called on this scope for the given binding, so warnings
+// about deprecated providers are only sent once per scope.
+func (scope *Scope) warnDeprecated(key binding) bool {
+	scope.mutex.Lock()
+	defer scope.mutex.Unlock()
+	if _, warned := scope.deprecations[key]; warned {
+		return false
+	}
+	scope.deprecations[key] = struct{}{}
+	return true
+}
+
+// Returns a view of this scope which is resolving the given type. If the type is already
+// being resolved in this resolution chain an ErrCircularDependency is returned which
+// describes the cycle.
+func (scope *Scope) resolve(key binding, lifetime Lifetime) (*Scope, error) {
+	for r := scope.resolving; r != nil; r = r.previous {
+		if r.key == key {
+			return nil, fmt.Errorf("%w: %s", ErrCircularDependency, scope.resolving.path(key))
+		}
+	}
+	view := scope.view()
+	view.resolving = &resolution{key: key, lifetime: lifetime, previous: scope.resolving}
+	return view, nil
+}
+
+// Returns a copy of this scope to track resolving, context, or recording in. The copy shares
+// the state of this scope but doesn't keep this scope from being garbage collected, so values
+// which keep the scope given to their provider don't keep it alive. See FreeOnGC.
+func (scope *Scope) view() *Scope {
+	view := *scope
+	return &view
+}
+
+// Returns an error wrapping ErrLifetimeViolation if the value being created by this scope
+// would outlive the value of the given binding it depends on, since the dependency would be
+// freed while it's still used. Transient values and values without a provider belong to
+// whatever uses them so they can always be depended on, and values seeded with ProvideValue
+// are never shorter lived than whoever seeded them.
+func (scope *Scope) checkLifetime(key binding) error {
+	if scope.resolving == nil {
+		return nil
+	}
+	return lifetimeViolation(scope.resolving.key, scope.resolving.lifetime, key, scope.instanceLink(key))
+}
+
+// Returns an error wrapping ErrLifetimeViolation if a value of the dependent with the given
+// lifetime would outlive the value of the dependency created by the given link.
+func lifetimeViolation(dependent binding, lifetime Lifetime, key binding, dependency link) error {
+	if dependency == nil {
+		return nil
+	}
+	if _, alias := dependency.(*aliasLink); alias || dependency.seeded() {
+		return nil
+	}
+	if dependency.lifetime() != LifetimeTransient && lifetime.lasts() > dependency.lifetime().lasts() {
+		return fmt.Errorf("%w: %s %s depends on %s %s", ErrLifetimeViolation, lifetime, dependent, dependency.lifetime(), key)
+	}
+	return nil
+}
+
+// Returns the parent of this scope which continues the resolution chain and context of this scope.
+func (scope *Scope) up() *Scope {
+	return scope.on(scope.parent)
+}
+
+// Returns the given scope which continues the resolution chain and context of this scope.
+func (scope *Scope) on(target *Scope) *Scope {
+	if target == nil || (scope.resolving == nil && scope.ctx == nil && scope.recording == nil) {
+		return target
+	}
+	view := target.view()
+	view.resolving = scope.resolving
+	view.ctx = scope.ctx
+	view.recording = scope.recording
+	return view
+}
+
+// Returns the path from the first time the given type was resolved to the given type.
+// ex: A -> B -> A
+func (r *resolution) path(key binding) string {
+	path := []string{key.String()}
+	for curr := r; curr != nil; curr = curr.previous {
+		path = append(path, curr.key.String())
+		if curr.key == key {
+			break
+		}
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return strings.Join(path, " -> ")
+}
+
+// Returns the instance stored directly on this scope for the given type.
+func (scope *Scope) getInstance(key binding) (any, bool) {
+	scope.mutex.RLock()
+	defer scope.mutex.RUnlock()
+	instance, exists := scope.instances[key]
+	return instance, exists
+}
+
+// Stores an instance directly on this scope for the given type.
+func (scope *Scope) setInstance(key binding, instance any) {
+	scope.mustNotBeFrozen(key.typ)
+	scope.storeInstance(key, instance, nil)
+}
+
+// Prevents providers and values from being registered on this scope. Registering panics or
+// returns an error wrapping ErrScopeFrozen afterwards, which catches registrations made after
+// the scope is supposed to be complete. Values are still resolved and created by providers
+// and cached as usual, and children of the scope are not frozen.
+func (scope *Scope) Freeze() {
+	scope.frozen.Store(true)
+}
+
+// Returns whether Freeze was called on this scope.
+func (scope *Scope) Frozen() bool {
+	return scope.frozen.Load()
+}
+
+// Returns an error wrapping ErrScopeFrozen for registering the given type if this scope is frozen.
+func (scope *Scope) checkFrozen(typ reflect.Type) error {
+	if scope.Frozen() {
+		return fmt.Errorf("%w: %s can't be registered", ErrScopeFrozen, typ)
+	}
+	return nil
+}
 
-	parent    *Scope
-	providers map[reflect.Type]link
-	instances map[reflect.Type]any
+// Panics with the error of checkFrozen if this scope is frozen.
+func (scope *Scope) mustNotBeFrozen(typ reflect.Type) {
+	if err := scope.checkFrozen(typ); err != nil {
+		panic(err)
+	}
 }
 
-// Creates a new scope with the global scope as the parent.
-func New() *Scope {
-	return new(global)
+// Returns the instance stored directly on this scope for the given type, or stores the given
+// instance if there isn't one and returns it. If the instance has to be stored on a frozen
+// scope an error wrapping ErrScopeFrozen is returned.
+func (scope *Scope) loadOrStoreInstance(key binding, instance any) (any, error) {
+	scope.mutex.Lock()
+	defer scope.mutex.Unlock()
+	if existing, exists := scope.instances[key]; exists {
+		return existing, nil
+	}
+	if err := scope.checkFrozen(key.typ); err != nil {
+		return nil, err
+	}
+	scope.order = append(scope.order, key)
+	scope.instances[key] = instance
+	return instance, nil
 }
 
-func new(parent *Scope) *Scope {
-	return &Scope{
-		parent:    parent,
-		providers: make(map[reflect.Type]link),
-		instances: make(map[reflect.Type]any),
+// Stores an instance directly on this scope for the given type along with the link which
+// created it, if any.
+func (scope *Scope) storeInstance(key binding, instance any, creator link) {
+	scope.mutex.Lock()
+	defer scope.mutex.Unlock()
+	if _, exists := scope.instances[key]; !exists {
+		scope.order = append(scope.order, key)
+	}
+	scope.instances[key] = instance
+	if creator != nil {
+		scope.creators[key] = creator
+	} else {
+		delete(scope.creators, key)
 	}
 }
 
-// Returns this scope's parent.
-func (scope *Scope) Parent() *Scope {
-	return scope.parent
+// Returns the link which created the instance on this scope for the given type, or the
+// link which would create it.
+func (scope *Scope) instanceLink(key binding) link {
+	scope.mutex.RLock()
+	creator := scope.creators[key]
+	scope.mutex.RUnlock()
+	if creator != nil {
+		return creator
+	}
+	return scope.getLink(key)
 }
 
-// Returns a child to this scope.
-func (scope *Scope) Spawn() *Scope {
-	return new(scope)
+// Removes the instance stored directly on this scope for the given type and returns it.
+func (scope *Scope) removeInstance(key binding) (any, bool) {
+	scope.mutex.Lock()
+	defer scope.mutex.Unlock()
+	instance, exists := scope.instances[key]
+	if exists {
+		delete(scope.instances, key)
+		delete(scope.creators, key)
+		for i, ordered := range scope.order {
+			if ordered == key {
+				scope.order = append(scope.order[:i], scope.order[i+1:]...)
+				break
+			}
+		}
+	}
+	return instance, exists
 }
 
-// Sets a value on this scope.
-func (scope *Scope) Set(value any) error {
-	key := reflect.TypeOf(value)
-	if key.Kind() != reflect.Pointer {
-		ptr := reflect.New(key)
-		ptr.Elem().Set(reflect.ValueOf(value))
-		scope.instances[key] = ptr.Interface()
-	} else {
-		scope.instances[key.Elem()] = value
+// Returns the bindings of all instances stored directly on this scope in the order
+// they were stored.
+func (scope *Scope) instanceKeys() []binding {
+	scope.mutex.RLock()
+	defer scope.mutex.RUnlock()
+	keys := make([]binding, len(scope.order))
+	copy(keys, scope.order)
+	return keys
+}
+
+// Returns a provider link for the given binding by looking in this scope and then parent scopes
+// until it finds a provider.
+func (scope *Scope) getLink(key binding) link {
+	scope.mutex.RLock()
+	l, exists := scope.providers[key]
+	scope.mutex.RUnlock()
+	if exists {
+		return l
+	} else if scope.parent != nil {
+		return scope.parent.getLink(key)
 	}
 	return nil
 }
 
-// Gets a value from this scope with the given type and potentially returns an error.
-// If it doesn't exist on this scope a provider is searched through the parent scopes.
-// If the provider has a lifetime of forever its created on the deepest scope, otherwise
-// scope and once lifetime values are stored in this scope.
-func (scope *Scope) Get(key reflect.Type) (any, error) {
-	if instance, exists := scope.instances[key]; exists {
-		return instance, nil
+// Returns the types the provider of the given type available to this scope needs to create
+// its value, which are the arguments of constructors registered with ProvideFunc or
+// ProvideFrom and the target of an alias. False is returned if there is no provider or its
+// dependencies aren't known, like providers with a Create function.
+func (scope *Scope) Dependencies(key reflect.Type) ([]reflect.Type, bool) {
+	link := scope.getLink(binding{typ: key})
+	if link == nil {
+		return nil, false
 	}
-	deepLink := scope.getLink(key)
-	if deepLink != nil && deepLink.lifetime() == LifetimeScope {
-		return deepLink.get(scope)
+	return link.dependencies()
+}
+
+// Checks that the dependencies of every provider available to this scope with known
+// dependencies can be resolved, without creating any values. An error wrapping ErrNoProvider
+// is returned for each dependency which can't be resolved, and an error wrapping
+// ErrLifetimeViolation for each dependency which doesn't live as long as the provider's value.
+// Dependencies are resolved from the scope the provider's value is created on.
+func (scope *Scope) Validate() error {
+	multi := multiError{}
+	seen := make(map[binding]bool)
+	for curr := scope; curr != nil; curr = curr.parent {
+		curr.mutex.RLock()
+		keys := make([]binding, 0, len(curr.providers))
+		for key := range curr.providers {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		links := make([]link, len(keys))
+		for i, key := range keys {
+			links[i] = curr.providers[key]
+		}
+		curr.mutex.RUnlock()
+
+		for i, key := range keys {
+			dependencies, known := links[i].dependencies()
+			if !known {
+				continue
+			}
+			from := scope
+			switch links[i].lifetime() {
+			case LifetimeForever:
+				from = curr
+			case LifetimeSingleton:
+				from = Global()
+			}
+			for _, dependency := range dependencies {
+				if !from.canResolve(dependency) {
+					multi.errors = append(multi.errors, fmt.Errorf("%w: %s depends on %s", ErrNoProvider, key, dependency))
+					continue
+				}
+				if dependency.Kind() == reflect.Pointer {
+					dependency = dependency.Elem()
+				}
+				dependencyKey := binding{typ: dependency}
+				if err := lifetimeViolation(key, links[i].lifetime(), dependencyKey, from.getLink(dependencyKey)); err != nil {
+					multi.errors = append(multi.errors, err)
+				}
+			}
+		}
 	}
-	link := scope.providers[key]
-	if link == nil {
-		dynamic := GetDynamic(key)
-		if dynamic != nil {
-			err := dynamic.ProvideDynamic(scope)
-			if err != nil {
-				return nil, err
+	if len(multi.errors) > 0 {
+		return multi
+	}
+	return nil
+}
+
+// Checks that every argument of the given functions can be resolved from this scope without
+// calling the functions or creating any values, which catches handlers that are missing
+// providers at startup. An error wrapping ErrNoProvider is returned for each argument which
+// can't be resolved, and an error wrapping ErrNotFunc for each value which isn't a function.
+// Like InvokeStrict struct and array arguments are hydrated so they can always be resolved.
+func (scope *Scope) DryRun(fns ...any) error {
+	multi := multiError{}
+	for i, fn := range fns {
+		fnType := reflect.TypeOf(fn)
+		if fnType == nil || fnType.Kind() != reflect.Func {
+			multi.errors = append(multi.errors, fmt.Errorf("%w: value %d is %T", ErrNotFunc, i, fn))
+			continue
+		}
+		n := fnType.NumIn()
+		if fnType.IsVariadic() {
+			n--
+		}
+		for j := 0; j < n; j++ {
+			if !scope.canResolve(fnType.In(j)) {
+				multi.errors = append(multi.errors, fmt.Errorf("%w: argument %d %s of %s", ErrNoProvider, j, fnType.In(j), fnType))
 			}
-			return dynamic, nil
 		}
-		if scope.Dynamic != nil {
-			dyn, err := scope.Dynamic(key, scope)
-			if err != nil {
-				return nil, err
+	}
+	if len(multi.errors) > 0 {
+		return multi
+	}
+	return nil
+}
+
+// Returns whether an argument of the given type can be resolved from this scope without
+// creating it. Struct and array arguments are always hydrated so they can be resolved.
+func (scope *Scope) canResolve(typ reflect.Type) bool {
+	if typ.Kind() == reflect.Struct || typ.Kind() == reflect.Array {
+		return true
+	}
+	if scope.has(binding{typ: typ}) {
+		return true
+	}
+	if _, exists := scope.getDefault(typ); exists {
+		return true
+	}
+	switch typ.Kind() {
+	case reflect.Pointer:
+		if scope.has(binding{typ: typ.Elem()}) {
+			return true
+		}
+		_, exists := scope.getDefault(typ.Elem())
+		return exists
+	case reflect.Slice:
+		return len(scope.groupMembers(typ.Elem())) > 0
+	}
+	return false
+}
+
+// Marks the given types as required on this scope and its children. When a function is invoked
+// with an argument of a required type, or a pointer to one, and it can't be resolved an error is
+// returned instead of passing the zero value. The same goes for hydrating struct fields of a
+// required type unless they're tagged optional. Panics if this scope is frozen.
+func (scope *Scope) Require(keys ...reflect.Type) {
+	for _, key := range keys {
+		scope.mustNotBeFrozen(key)
+	}
+	scope.mutex.Lock()
+	defer scope.mutex.Unlock()
+	for _, key := range keys {
+		scope.required[key] = struct{}{}
+	}
+}
+
+// Returns whether the given type was marked as required on this scope or its parents.
+func (scope *Scope) isRequired(key reflect.Type) bool {
+	scope.mutex.RLock()
+	_, required := scope.required[key]
+	scope.mutex.RUnlock()
+	if !required && scope.parent != nil {
+		return scope.parent.isRequired(key)
+	}
+	return required
+}
+
+// A provider in the dependency graph of a scope and the types it depends on.
+type GraphEdge struct {
+	// The provided type.
+	Type string `json:"type"`
+	// The name of the provider if it's a named provider.
+	Name string `json:"name,omitempty"`
+	// The lifetime of the provided value.
+	Lifetime Lifetime `json:"lifetime"`
+	// The types requested by the provider when it creates its value.
+	Dependencies []string `json:"dependencies"`
+}
+
+// Returns the dependency graph of all providers available to this scope. The dependencies of a
+// provider are discovered by calling its create function with a scope that records requested
+// types and returns zero values instead of resolving them. The values created this way are
+// discarded and any panics are recovered, but create functions with side effects will still
+// have them. Providers on this scope are first followed by providers on its parents which are
+// not overridden.
+func (scope *Scope) Graph() []GraphEdge {
+	edges := []GraphEdge{}
+	seen := make(map[binding]bool)
+	for curr := scope; curr != nil; curr = curr.parent {
+		curr.mutex.RLock()
+		keys := make([]binding, 0, len(curr.providers))
+		links := make(map[binding]link, len(curr.providers))
+		for key, link := range curr.providers {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+				links[key] = link
 			}
-			if dyn != nil {
-				return dyn, nil
+		}
+		curr.mutex.RUnlock()
+
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+
+		for _, key := range keys {
+			link := links[key]
+			dependencies := []string{}
+			for _, dependency := range scope.record(link) {
+				dependencies = append(dependencies, dependency.String())
 			}
+			edges = append(edges, GraphEdge{
+				Type:         key.typ.String(),
+				Name:         key.name,
+				Lifetime:     link.lifetime(),
+				Dependencies: dependencies,
+			})
 		}
-		if scope.parent != nil {
-			par, err := scope.parent.Get(key)
-			if err == nil || err != ErrNoProvider {
-				return par, err
+	}
+	return edges
+}
+
+// Calls the create function of the link with a view of this scope which records the
+// bindings requested instead of resolving them.
+func (scope *Scope) record(link link) []binding {
+	view := scope.view()
+	view.recording = &recorder{}
+	func() {
+		defer func() {
+			recover()
+		}()
+		link.create(view)
+	}()
+	return view.recording.keys
+}
+
+// Creates the values of all providers on this scope that don't have a lifetime of once or
+// transient and have not been created yet. Values are resolved like Get so they're stored
+// where their lifetime says, and providers which depend on other providers will create them
+// first as they normally would. All errors are returned together.
+func (scope *Scope) Warmup() error {
+	scope.mutex.RLock()
+	keys := make([]binding, 0, len(scope.providers))
+	for key, link := range scope.providers {
+		if link.lifetime() != LifetimeOnce && link.lifetime() != LifetimeTransient {
+			keys = append(keys, key)
+		}
+	}
+	scope.mutex.RUnlock()
+
+	multi := multiError{}
+	for _, key := range keys {
+		_, err := scope.get(key)
+		if err != nil {
+			multi.errors = append(multi.errors, err)
+		}
+	}
+	if len(multi.errors) > 0 {
+		return multi
+	}
+	return nil
+}
+
+// Replaces the provider on this scope for the given binding and returns the previous provider.
+// If replacement is nil the provider is removed. Any value cached on this scope for the binding
+// is freed first.
+func (scope *Scope) swapProvider(key binding, replacement link) link {
+	if _, exists := scope.getInstance(key); exists {
+		if current := scope.instanceLink(key); current != nil {
+			current.free(scope)
+		} else {
+			scope.removeInstance(key)
+		}
+	}
+	scope.mutex.Lock()
+	defer scope.mutex.Unlock()
+	previous := scope.providers[key]
+	if replacement != nil {
+		scope.providers[key] = replacement
+	} else {
+		delete(scope.providers, key)
+	}
+	return previous
+}
+
+// Re-evaluates the value of the given type with its provider's Refresh on the nearest scope
+// with the value, and replaces the value when Refresh says it changed. Returns whether the
+// value was replaced. Nothing happens if the value hasn't been created or its provider has no
+// Refresh. Unlike Invalidate the value is replaced right away instead of when it's requested.
+func (scope *Scope) Refresh(key reflect.Type) (bool, error) {
+	typeKey := binding{typ: key}
+	for curr := scope; curr != nil; curr = curr.parent {
+		if _, exists := curr.getInstance(typeKey); exists {
+			if link := curr.instanceLink(typeKey); link != nil {
+				return link.refresh(curr)
 			}
+			return false, nil
 		}
-		return nil, ErrNoProvider
 	}
-	return link.get(scope)
+	return false, nil
 }
 
-// Returns a provider link for the given type by looking in this scope and then parent scopes
-// until it finds a provider.
-func (scope *Scope) getLink(key reflect.Type) link {
-	if l, exists := scope.providers[key]; exists {
-		return l
-	} else if scope.parent != nil {
-		return scope.parent.getLink(key)
+// Frees the value of the given type cached on this scope so it's created again the next time
+// it's requested. The provider's Free is called for the value, or if it was set without a
+// provider it's removed. If there is no value cached on this scope nothing happens.
+func (scope *Scope) Invalidate(key reflect.Type) error {
+	return scope.invalidate(binding{typ: key})
+}
+
+func (scope *Scope) invalidate(key binding) error {
+	if _, exists := scope.getInstance(key); !exists {
+		return nil
 	}
+	if link := scope.instanceLink(key); link != nil {
+		return link.free(scope)
+	}
+	scope.removeInstance(key)
 	return nil
 }
 
-// Frees all values in this scope with a lifetime of once.
+// Frees all values in this scope with a lifetime of once in the reverse order they were created.
+// Values whose provider has a Reset are reset and kept for reuse instead.
 func (scope *Scope) FreeOnce() error {
 	multi := multiError{}
-	for key := range scope.instances {
-		if link := scope.getLink(key); link != nil {
-			if link.lifetime() == LifetimeOnce {
-				err := link.free(scope)
-				if err != nil {
-					multi.errors = append(multi.errors, err)
-				}
+	keys := scope.instanceKeys()
+	for i := len(keys) - 1; i >= 0; i-- {
+		key := keys[i]
+		if link := scope.instanceLink(key); link != nil && link.lifetime() == LifetimeOnce {
+			reset, err := link.reset(scope)
+			if !reset {
+				err = link.free(scope)
+			}
+			if err != nil {
+				multi.errors = append(multi.errors, err)
 			}
-		} else {
-			delete(scope.instances, key)
 		}
 	}
 	if len(multi.errors) > 0 {
@@ -351,17 +3003,87 @@ func (scope *Scope) FreeOnce() error {
 	return nil
 }
 
-// Frees all values in this scope.
+// Frees all values in this scope in the reverse order they were created, so values are freed
+// before the values they depend on.
 func (scope *Scope) Free() error {
 	multi := multiError{}
-	for key := range scope.instances {
-		if link := scope.getLink(key); link != nil {
+	keys := scope.instanceKeys()
+	for i := len(keys) - 1; i >= 0; i-- {
+		key := keys[i]
+		if link := scope.instanceLink(key); link != nil {
 			err := link.free(scope)
 			if err != nil {
 				multi.errors = append(multi.errors, err)
 			}
 		} else {
-			delete(scope.instances, key)
+			scope.removeInstance(key)
+		}
+	}
+	if len(multi.errors) > 0 {
+		return multi
+	}
+	return nil
+}
+
+// Frees the values in this scope created by providers with the given tag in the reverse order
+// they were created. Values without the tag remain in the scope.
+func (scope *Scope) FreeTagged(tag string) error {
+	multi := multiError{}
+	keys := scope.instanceKeys()
+	for i := len(keys) - 1; i >= 0; i-- {
+		link := scope.instanceLink(keys[i])
+		if link == nil || !hasTag(link, tag) {
+			continue
+		}
+		err := link.free(scope)
+		if err != nil {
+			multi.errors = append(multi.errors, err)
+		}
+	}
+	if len(multi.errors) > 0 {
+		return multi
+	}
+	return nil
+}
+
+// Returns whether the link has the given tag.
+func hasTag(link link, tag string) bool {
+	for _, linkTag := range link.tags() {
+		if linkTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Frees all values in this scope and all scopes spawned from it that are still reachable.
+// Children are freed before their parents. Unlike Free this cascades to children, which
+// is useful for long lived scopes which spawn a scope per connection or request.
+func (scope *Scope) FreeTree() error {
+	scope.mutex.RLock()
+	children := make([]*Scope, 0, len(scope.children))
+	for ref := range scope.children {
+		if child := ref.Value(); child != nil {
+			children = append(children, child)
+		}
+	}
+	scope.mutex.RUnlock()
+
+	multi := multiError{}
+	for _, child := range children {
+		if err := child.FreeTree(); err != nil {
+			if childMulti, ok := err.(multiError); ok {
+				multi.errors = append(multi.errors, childMulti.errors...)
+			} else {
+				multi.errors = append(multi.errors, err)
+			}
+		}
+	}
+	if err := scope.Free(); err != nil {
+		if freeMulti, ok := err.(multiError); ok {
+			multi.errors = append(multi.errors, freeMulti.errors...)
+		} else {
+			multi.errors = append(multi.errors, err)
 		}
 	}
 	if len(multi.errors) > 0 {
@@ -374,25 +3096,193 @@ func (scope *Scope) Free() error {
 // types of provided values it updates them. Once the hydrated values are doing being used
 // scope.FreeOnce() should be called.
 func (scope *Scope) Hydrate(value any) error {
+	defer runtime.KeepAlive(scope)
+	val := reflect.ValueOf(value)
+	if val.Kind() != reflect.Pointer {
+		return ErrNotPointer
+	}
+	h := &hydration{}
+	h.visit(val)
+	err := scope.hydrateValue(val, h)
+	return err
+}
+
+// Hydrates each of the given values like Hydrate and then frees the values with a lifetime of
+// once, so they're shared by all of the values. If any value is not a pointer nothing is
+// hydrated and an error wrapping ErrNotPointer is returned for each of them. Errors hydrating
+// the values are returned together and don't stop the remaining values from being hydrated.
+func (scope *Scope) HydrateAll(values ...any) error {
+	multi := multiError{}
+	for i, value := range values {
+		if reflect.ValueOf(value).Kind() != reflect.Pointer {
+			multi.errors = append(multi.errors, fmt.Errorf("%w: value %d is %T", ErrNotPointer, i, value))
+		}
+	}
+	if len(multi.errors) > 0 {
+		return multi
+	}
+	for i, value := range values {
+		if err := scope.Hydrate(value); err != nil {
+			multi.errors = append(multi.errors, fmt.Errorf("%w: value %d", err, i))
+		}
+	}
+	if err := scope.FreeOnce(); err != nil {
+		multi.errors = append(multi.errors, err)
+	}
+	if len(multi.errors) > 0 {
+		return multi
+	}
+	return nil
+}
+
+// Hydrates the value like Hydrate and calls visit with each field and element considered,
+// whether or not it was set to a provided value. The path is the dotted names of the fields
+// from the value with the indices of slice and array elements and the keys of map values,
+// like "DB.Replicas[0]". Pointers are reported with the same path as the value they point to.
+func (scope *Scope) HydrateVisit(value any, visit func(path string, typ reflect.Type, set bool)) error {
 	val := reflect.ValueOf(value)
 	if val.Kind() != reflect.Pointer {
 		return ErrNotPointer
 	}
-	err := scope.hydrateValue(val)
+	h := &hydration{visitor: visit}
+	h.visit(val)
+	return scope.hydrateValue(val, h)
+}
+
+// Hydrates the value like Hydrate and returns whether any part of it was set to a provided
+// value which differs from what it was before. This is useful for skipping work when
+// rehydrating a value didn't change it.
+func (scope *Scope) HydrateChanged(value any) (bool, error) {
+	val := reflect.ValueOf(value)
+	if val.Kind() != reflect.Pointer {
+		return false, ErrNotPointer
+	}
+	h := &hydration{tracking: true}
+	h.visit(val)
+	err := scope.hydrateValue(val, h)
+	return h.changed, err
+}
+
+// The state of a single hydration.
+type hydration struct {
+	visited  map[visit]bool
+	tracking bool
+	changed  bool
+	depth    int
+	// Called with the path of each field and element considered when set.
+	visitor func(path string, typ reflect.Type, set bool)
+	path    string
+}
+
+// Adds the part to the path of the value being hydrated when there is a visitor and returns a
+// function which removes it.
+func (h *hydration) enter(part func() string) func() {
+	if h.visitor == nil {
+		return func() {}
+	}
+	previous := h.path
+	h.path += part()
+	return func() { h.path = previous }
+}
+
+// Calls the visitor with the current path if there is one.
+func (h *hydration) report(typ reflect.Type, set bool) {
+	if h.visitor != nil && h.path != "" {
+		h.visitor(h.path, typ, set)
+	}
+}
+
+// Sets the value the pointer points to, and if changes are being tracked records whether
+// the value differs from the current value.
+func (h *hydration) set(ptr reflect.Value, value reflect.Value) {
+	if h.tracking && !h.changed && !reflect.DeepEqual(ptr.Elem().Interface(), value.Interface()) {
+		h.changed = true
+	}
+	ptr.Elem().Set(value)
+}
+
+// A pointer that was followed during hydration.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+// Marks the pointer as visited and returns whether it was already visited.
+func (h *hydration) visit(ptr reflect.Value) bool {
+	if h.visited == nil {
+		h.visited = make(map[visit]bool)
+	}
+	v := visit{ptr: ptr.Pointer(), typ: ptr.Type()}
+	if h.visited[v] {
+		return true
+	}
+	h.visited[v] = true
+	return false
+}
+
+// Hydrates a pointer to a value.
+func (scope *Scope) hydrateValue(ptr reflect.Value, h *hydration) error {
+	maxDepth := scope.HydrateMaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultHydrateMaxDepth
+	}
+	if h.depth >= maxDepth {
+		return fmt.Errorf("%w: %d at %s", ErrHydrateDepthExceeded, maxDepth, ptr.Type().Elem())
+	}
+	h.depth++
+	defer func() { h.depth-- }()
+
+	err := scope.hydrateProvided(ptr, h)
+	if err == nil || err == ErrNoProvider {
+		h.report(ptr.Type().Elem(), err == nil && ptr.Elem().CanSet())
+	}
+	if err != ErrNoProvider {
+		return err
+	}
+	return scope.hydrateElements(ptr.Elem(), h)
+}
+
+// Hydrates a pointer to a value with the provided value of the pointer's type. If there
+// is no provided value ErrNoProvider is returned, and if the provider created a nil value
+// ErrNilValue is returned.
+func (scope *Scope) hydrateProvided(ptr reflect.Value, h *hydration) error {
+	key := ptr.Type().Elem()
+	val, err := scope.Get(key)
+	if err == nil && reflect.ValueOf(val).IsNil() {
+		return fmt.Errorf("%w: provided %s", ErrNilValue, key)
+	}
+	if err == nil && ptr.Elem().CanSet() {
+		h.set(ptr, reflect.ValueOf(val).Elem())
+	}
 	return err
 }
 
-// Hydrates a pointer to a value.
-func (scope *Scope) hydrateValue(ptr reflect.Value) error {
-	key := ptr.Type().Elem()
-	val, err := scope.Get(key)
-	if err != ErrNoProvider {
-		if err == nil && ptr.Elem().CanSet() {
-			ptr.Elem().Set(reflect.ValueOf(val).Elem())
-		}
-		return err
+// Hydrates a pointer to a slice with all members in the group of the slice's element type.
+// If the group has no members ErrNoProvider is returned.
+func (scope *Scope) hydrateGroup(ptr reflect.Value) error {
+	sliceType := ptr.Type().Elem()
+	instances, err := scope.getGroup(sliceType.Elem())
+	if err != nil {
+		return err
+	}
+	if len(instances) == 0 {
+		return ErrNoProvider
+	}
+	slice := reflect.MakeSlice(sliceType, len(instances), len(instances))
+	for i, instance := range instances {
+		slice.Index(i).Set(reflect.ValueOf(instance).Elem())
+	}
+	ptr.Elem().Set(slice)
+	return nil
+}
+
+// Hydrates the elements, fields, or values of an array, slice, struct, or map, or the value
+// a pointer points to. Pointers are only followed the first time they're visited so values
+// which reference themselves don't hydrate forever.
+func (scope *Scope) hydrateElements(inner reflect.Value, h *hydration) error {
+	if scope.HydrateNilSlices && inner.Kind() == reflect.Slice && inner.IsNil() && inner.CanSet() && scope.provides(binding{typ: inner.Type().Elem()}, false) {
+		inner.Set(reflect.MakeSlice(inner.Type(), 1, 1))
 	}
-	inner := ptr.Elem()
 
 	switch inner.Kind() {
 	case reflect.Chan, reflect.Slice, reflect.Func, reflect.Pointer, reflect.Interface:
@@ -402,23 +3292,55 @@ func (scope *Scope) hydrateValue(ptr reflect.Value) error {
 	}
 
 	switch inner.Kind() {
+	case reflect.Pointer:
+		if !h.visit(inner) {
+			return scope.hydrateValue(inner, h)
+		}
 	case reflect.Array, reflect.Slice:
 		n := inner.Len()
 		for i := 0; i < n; i++ {
 			item := inner.Index(i)
+			if item.Kind() == reflect.Pointer && item.IsNil() && item.CanSet() && scope.provides(binding{typ: item.Type().Elem()}, false) {
+				item.Set(reflect.New(item.Type().Elem()))
+			}
 			if item.CanAddr() {
-				err := scope.hydrateValue(item.Addr())
+				leave := h.enter(func() string { return fmt.Sprintf("[%d]", i) })
+				err := scope.hydrateValue(item.Addr(), h)
+				leave()
 				if err != nil {
 					return err
 				}
 			}
 		}
 	case reflect.Struct:
-		n := inner.NumField()
-		for i := 0; i < n; i++ {
-			field := inner.Field(i)
+		for _, plan := range structPlan(inner.Type()) {
+			field := inner.Field(plan.index)
 			if field.CanAddr() {
-				err := scope.hydrateValue(field.Addr())
+				fieldPtr := field.Addr()
+				if !field.CanSet() && scope.HydrateUnexported {
+					fieldPtr = reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr()))
+				}
+				tag := plan.tag
+				leave := h.enter(func() string {
+					name := inner.Type().Field(plan.index).Name
+					if h.path == "" {
+						return name
+					}
+					return "." + name
+				})
+				var err error
+				if tag.name != "" {
+					err = scope.hydrateNamed(fieldPtr, tag.name, h)
+				} else {
+					err = scope.hydrateValue(fieldPtr, h)
+				}
+				if err == nil && plan.embeddedPointer && field.IsNil() && field.CanSet() {
+					err = scope.hydrateEmbedded(field, h)
+				}
+				if err == nil && !tag.optional {
+					err = scope.checkRequiredField(inner.Type(), plan.index, tag.name)
+				}
+				leave()
 				if err != nil {
 					return err
 				}
@@ -429,7 +3351,12 @@ func (scope *Scope) hydrateValue(ptr reflect.Value) error {
 		for _, key := range keys {
 			value := inner.MapIndex(key)
 			newValue := reflect.New(value.Type())
-			err := scope.hydrateValue(newValue)
+			if !value.IsZero() {
+				newValue.Elem().Set(value)
+			}
+			leave := h.enter(func() string { return fmt.Sprintf("[%v]", key) })
+			err := scope.hydrateValue(newValue, h)
+			leave()
 			if err != nil {
 				return err
 			}
@@ -439,25 +3366,356 @@ func (scope *Scope) hydrateValue(ptr reflect.Value) error {
 	return nil
 }
 
-// Returns a hydrated value of the given type.
+// The fields of struct types to hydrate by type, built once per type.
+var structPlans sync.Map
+
+// A field of a struct to hydrate.
+type fieldPlan struct {
+	index           int
+	tag             fieldTag
+	embeddedPointer bool
+}
+
+// Returns the fields of the struct type which should be hydrated along with their parsed
+// tags. Fields tagged with "-" are not included.
+func structPlan(typ reflect.Type) []fieldPlan {
+	if plan, ok := structPlans.Load(typ); ok {
+		return plan.([]fieldPlan)
+	}
+	n := typ.NumField()
+	plan := make([]fieldPlan, 0, n)
+	for i := 0; i < n; i++ {
+		tag := parseFieldTag(typ.Field(i).Tag.Get("deps"))
+		if !tag.skip {
+			field := typ.Field(i)
+			plan = append(plan, fieldPlan{
+				index:           i,
+				tag:             tag,
+				embeddedPointer: field.Anonymous && field.Type.Kind() == reflect.Pointer && field.Type.Elem().Kind() == reflect.Struct,
+			})
+		}
+	}
+	actual, _ := structPlans.LoadOrStore(typ, plan)
+	return actual.([]fieldPlan)
+}
+
+// The options of a deps struct tag. The tag is a comma separated list of a provider name
+// and options, "-" skips the field and "optional" leaves the field as is when its type is
+// required but not provided. Errors creating the field's value are returned either way.
+//
+//	Primary *DB `deps:"primary,optional"`
+//	Secret  []byte `deps:"-"`
+type fieldTag struct {
+	name     string
+	skip     bool
+	optional bool
+}
+
+// Parses the value of a deps struct tag.
+func parseFieldTag(tag string) fieldTag {
+	parsed := fieldTag{}
+	if tag == "-" {
+		parsed.skip = true
+		return parsed
+	}
+	for _, part := range strings.Split(tag, ",") {
+		switch part {
+		case "optional":
+			parsed.optional = true
+		default:
+			parsed.name = part
+		}
+	}
+	return parsed
+}
+
+// Returns an error wrapping ErrNoProvider if the type of the struct field, or the type it
+// points to, is required and has no value, provider, or default available to this scope.
+func (scope *Scope) checkRequiredField(structType reflect.Type, index int, name string) error {
+	field := structType.Field(index)
+	typ := field.Type
+	if !scope.isRequired(typ) && !(typ.Kind() == reflect.Pointer && scope.isRequired(typ.Elem())) {
+		return nil
+	}
+	resolved := false
+	if name != "" {
+		resolved = scope.has(binding{typ: typ, name: name}) || (typ.Kind() == reflect.Pointer && scope.has(binding{typ: typ.Elem(), name: name}))
+	} else {
+		resolved = scope.canResolve(typ)
+	}
+	if resolved {
+		return nil
+	}
+	return fmt.Errorf("%w: %s of field %s.%s is required", ErrNoProvider, typ, structType, field.Name)
+}
+
+// Hydrates a nil embedded struct pointer by allocating a struct and hydrating it. The field
+// is only set if hydrating the struct set any of its values.
+func (scope *Scope) hydrateEmbedded(field reflect.Value, h *hydration) error {
+	embedded := reflect.New(field.Type().Elem())
+	sub := &hydration{visited: h.visited, tracking: true, depth: h.depth, visitor: h.visitor, path: h.path}
+	err := scope.hydrateValue(embedded, sub)
+	if err != nil {
+		return err
+	}
+	if sub.changed {
+		h.set(field.Addr(), embedded)
+	}
+	return nil
+}
+
+// Hydrates a pointer to a value with the value provided under the given name. A pointer
+// value without a named provider of its type is set to the value of the named provider of
+// the type it points to. If there is no named provider the value is left as is.
+func (scope *Scope) hydrateNamed(ptr reflect.Value, name string, h *hydration) error {
+	typ := ptr.Type().Elem()
+	val, err := scope.get(binding{typ: typ, name: name})
+	value := reflect.ValueOf(val)
+	if err == ErrNoProvider && typ.Kind() == reflect.Pointer {
+		val, err = scope.get(binding{typ: typ.Elem(), name: name})
+		value = reflect.New(typ)
+		if err == nil {
+			value.Elem().Set(reflect.ValueOf(val))
+		}
+	}
+	if err == ErrNoProvider {
+		h.report(typ, false)
+		return nil
+	}
+	if err == nil && ptr.Elem().CanSet() {
+		h.set(ptr, value.Elem())
+		h.report(typ, true)
+	}
+	return err
+}
+
+// Returns a hydrated value of the given type. If the type or the type it points to is not
+// provided its default value is used, a pointer to a copy of the default if it's a pointer.
+// If there is no default and it's not a struct or array with hydrated elements then the
+// zero value is returned with ErrNoProvider. If the type is required the ErrNoProvider is wrapped with
+// the type so it's not ignored by Invoke.
 func (scope *Scope) hydrateType(key reflect.Type) (reflect.Value, error) {
 	if key.Kind() == reflect.Pointer {
 		val, err := scope.Get(key.Elem())
 		if err != ErrNoProvider {
 			return reflect.ValueOf(val), err
 		}
+		if def, exists := scope.getDefault(key.Elem()); exists {
+			val := reflect.New(key.Elem())
+			val.Elem().Set(reflect.ValueOf(def).Elem())
+			return val, nil
+		}
 	}
 	val := reflect.New(key)
-	err := scope.hydrateValue(val)
+	h := &hydration{}
+	err := scope.hydrateProvided(val, h)
+	if err == ErrNoProvider && key.Kind() == reflect.Slice {
+		err = scope.hydrateGroup(val)
+	}
+	if err == ErrNoProvider {
+		if def, exists := scope.getDefault(key); exists {
+			val.Elem().Set(reflect.ValueOf(def).Elem())
+			err = nil
+		}
+	}
+	if err == ErrNoProvider {
+		err = scope.hydrateElements(val.Elem(), h)
+		if err == nil && key.Kind() != reflect.Struct && key.Kind() != reflect.Array {
+			err = ErrNoProvider
+		}
+	}
+	if err == ErrNoProvider && (scope.isRequired(key) || (key.Kind() == reflect.Pointer && scope.isRequired(key.Elem()))) {
+		err = fmt.Errorf("%w: %s is required", ErrNoProvider, key)
+	}
 	return val.Elem(), err
 }
 
+// Returns the slice passed to a variadic argument of the given slice type. If the slice
+// type is provided that is used, otherwise if the element type is provided a slice with
+// that one element is returned, otherwise an empty slice is returned.
+func (scope *Scope) hydrateVariadic(key reflect.Type) (reflect.Value, error) {
+	val, err := scope.Get(key)
+	if err == nil {
+		return reflect.ValueOf(val).Elem(), nil
+	}
+	if err != ErrNoProvider {
+		return reflect.Value{}, err
+	}
+	elem := key.Elem()
+	if (elem.Kind() == reflect.Struct || elem.Kind() == reflect.Array) && !scope.provides(binding{typ: elem}, false) {
+		if _, exists := scope.getDefault(elem); !exists {
+			return reflect.MakeSlice(key, 0, 0), nil
+		}
+	}
+	item, err := scope.hydrateType(elem)
+	if err == ErrNoProvider {
+		return reflect.MakeSlice(key, 0, 0), nil
+	}
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return reflect.Append(reflect.MakeSlice(key, 0, 1), item), nil
+}
+
+// Invokes the given function like Invoke but any context.Context arguments are given ctx
+// and providers can access ctx through scope.Context() while the function is invoked.
+// The context is only available for the duration of the invoke.
+func (scope *Scope) InvokeContext(ctx context.Context, fn any) (Result, error) {
+	view := scope.view()
+	view.ctx = ctx
+	return view.Invoke(fn)
+}
+
 // Invokes the given function by providing arguments of the requested types with
 // values found or provided in this scope and its parents. If the function has a pointer
 // argument to a provided type and the provider has a AfterPointerUse defined it will
 // be called after the function returns. If any values were created on this scope with
 // a lifetime of once they will be freed after the function returns.
+//
+// A pointer argument is given the value stored in the scope, so changes to it are seen by
+// everything else using the value. A non-pointer argument is given a copy of the value, so
+// changes to it are not seen by the scope, though like any copy in Go it still shares the
+// memory its pointers, slices, and maps reference.
 func (scope *Scope) Invoke(fn any) (Result, error) {
+	return scope.invoke(fn, invokeOptions{})
+}
+
+// Invokes the given function like Invoke but if any arguments are not provided an error
+// wrapping ErrNoProvider is returned listing every missing argument and the function is
+// not called. Struct and array arguments are hydrated and are not required to be provided.
+func (scope *Scope) InvokeStrict(fn any) (Result, error) {
+	return scope.invoke(fn, invokeOptions{strict: true})
+}
+
+// Invokes the given function like Invoke but if the function panics the panic is recovered and
+// returned as a *PanicError. Pointer uses and freeing once values still happen after a panic.
+func (scope *Scope) InvokeSafe(fn any) (Result, error) {
+	return scope.invoke(fn, invokeOptions{safe: true})
+}
+
+// Invokes the given function like Invoke but each override is passed as the argument with
+// its type instead of the resolved value. Overrides take precedence over values and
+// providers, and an override which doesn't match an argument returns an error wrapping
+// ErrInvalidValue without calling the function.
+//
+//	scope.InvokeWith(func(db *DB, req *Request) { ... }, req)
+func (scope *Scope) InvokeWith(fn any, overrides ...any) (Result, error) {
+	return scope.invoke(fn, invokeOptions{overrides: overrides})
+}
+
+// Invokes the method with the given name on a receiver resolved from this scope like Invoke.
+// Only the type of recv is used, so a nil pointer can be given for pointer receivers. The
+// arguments of the method are resolved like Invoke. If the type has no such method an error
+// wrapping ErrNotFunc is returned and if the receiver is not provided an error wrapping
+// ErrNoProvider is returned. Method expressions can also be given to Invoke directly since
+// their receiver is their first argument.
+//
+//	scope.InvokeMethod((*Service)(nil), "Handle")
+func (scope *Scope) InvokeMethod(recv any, methodName string) (Result, error) {
+	recvType := reflect.TypeOf(recv)
+	if recvType == nil {
+		return nil, ErrInvalidValue
+	}
+	if _, exists := recvType.MethodByName(methodName); !exists {
+		return nil, fmt.Errorf("%w: %s has no method %s", ErrNotFunc, recvType, methodName)
+	}
+	if recvType.Kind() != reflect.Pointer && !scope.has(binding{typ: recvType}) {
+		return nil, fmt.Errorf("%w: receiver %s", ErrNoProvider, recvType)
+	}
+	recvValue, err := scope.hydrateType(recvType)
+	if err == ErrNoProvider || (err == nil && recvType.Kind() == reflect.Pointer && recvValue.IsNil()) {
+		err = fmt.Errorf("%w: receiver %s", ErrNoProvider, recvType)
+	}
+	if err != nil {
+		scope.FreeOnce()
+		return nil, err
+	}
+	return scope.invoke(recvValue.MethodByName(methodName).Interface(), invokeOptions{})
+}
+
+// Invokes the given function like Invoke but the arguments at the indices of the given names
+// are resolved by the bindings with those names, like GetNamed. This allows arguments of the
+// same type to be given different values since Go doesn't expose the names of parameters. If
+// a named binding is not provided an error wrapping ErrNoProvider is returned without
+// calling the function.
+//
+//	scope.InvokeNamed(func(primary *DB, replica *DB) { ... }, map[int]string{0: "primary", 1: "replica"})
+func (scope *Scope) InvokeNamed(fn any, names map[int]string) (Result, error) {
+	return scope.invoke(fn, invokeOptions{names: names})
+}
+
+// Invokes the given function like Invoke and returns the first non-nil error returned by
+// the function, or the error resolving its arguments. This is useful for handler functions
+// which only return an error.
+func (scope *Scope) InvokeErr(fn any) error {
+	result, err := scope.Invoke(fn)
+	if err != nil {
+		return err
+	}
+	return result.Err()
+}
+
+// Invokes the constructor like Invoke and sets each of its results on this scope except
+// errors. If the constructor returns a non-nil error nothing is set and the error is
+// returned, and if any other result is nil an error wrapping ErrNilValue is returned.
+//
+//	scope.Construct(func(cfg *Config) (*DB, error) { ... })
+func (scope *Scope) Construct(ctor any) error {
+	result, err := scope.Invoke(ctor)
+	if err != nil {
+		return err
+	}
+	if err := result.Err(); err != nil {
+		return err
+	}
+	ctorType := reflect.TypeOf(ctor)
+	values := make([]any, 0, len(result))
+	for i, value := range result {
+		if ctorType.Out(i) == errorType {
+			continue
+		}
+		if IsNil(value) {
+			return fmt.Errorf("%w: result %d %s", ErrNilValue, i, ctorType.Out(i))
+		}
+		values = append(values, value)
+	}
+	for _, value := range values {
+		if err := scope.Set(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Invokes the given function like Invoke and returns its results by their declared types.
+// If multiple results have the same type the last one is returned.
+func (scope *Scope) InvokeMap(fn any) (map[reflect.Type]any, error) {
+	result, err := scope.Invoke(fn)
+	if err != nil {
+		return nil, err
+	}
+	fnType := reflect.TypeOf(fn)
+	results := make(map[reflect.Type]any, len(result))
+	for i, value := range result {
+		results[fnType.Out(i)] = value
+	}
+	return results, nil
+}
+
+// How a function is invoked.
+type invokeOptions struct {
+	// If an error should be returned when arguments aren't provided.
+	strict bool
+	// Values passed as the arguments of their types instead of resolved values.
+	overrides []any
+	// If a panic in the function should be returned as an error.
+	safe bool
+	// The names of the bindings given to arguments by their index.
+	names map[int]string
+}
+
+func (scope *Scope) invoke(fn any, options invokeOptions) (Result, error) {
+	defer runtime.KeepAlive(scope)
 	fnValue := reflect.ValueOf(fn)
 	fnType := reflect.TypeOf(fn)
 
@@ -465,42 +3723,221 @@ func (scope *Scope) Invoke(fn any) (Result, error) {
 		return nil, ErrNotFunc
 	}
 
+	overridden, err := overrideArgs(fnType, options.overrides)
+	if err != nil {
+		return nil, err
+	}
+	if err := scope.nameArgs(fnType, options.names, overridden); err != nil {
+		scope.FreeOnce()
+		return nil, err
+	}
+
+	args, err := scope.resolveArgs(fnType, options.strict, overridden)
+	if err != nil {
+		scope.FreeOnce()
+		return nil, err
+	}
+
+	provided := make([]reflect.Value, 0, len(args))
+	for i, arg := range args {
+		if _, ok := overridden[i]; !ok {
+			provided = append(provided, arg)
+		}
+	}
+
+	err = scope.usePointers(provided, link.beforePointerUse)
+	if err != nil {
+		scope.FreeOnce()
+		return nil, err
+	}
+
+	var resultsReflect []reflect.Value
+	var panicErr error
+	if options.safe {
+		resultsReflect, panicErr = callSafe(fnValue, args)
+	} else {
+		resultsReflect = call(fnValue, args)
+	}
+
+	err = scope.usePointers(provided, link.afterPointerUse)
+	scope.FreeOnce()
+	if panicErr != nil {
+		return nil, panicErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]any, len(resultsReflect))
+	for i := 0; i < len(results); i++ {
+		results[i] = resultsReflect[i].Interface()
+	}
+	return Result(results), nil
+}
+
+// Calls use for each pointer argument which points to a provided type with the
+// provider's link and the argument. The first error returned by use is returned.
+func (scope *Scope) usePointers(args []reflect.Value, use func(link link, scope *Scope, value any) error) error {
+	for _, argValue := range args {
+		if argValue.Kind() == reflect.Pointer {
+			key := binding{typ: argValue.Type().Elem()}
+			if link := scope.getLink(key); link != nil {
+				err := use(link, scope, argValue.Interface())
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the overrides by the index of the argument of the function type they are passed as.
+// Each override is passed as the first argument of the same type, or otherwise the first
+// argument it's assignable to. If an override doesn't match an argument an error wrapping
+// ErrInvalidValue is returned.
+func overrideArgs(fnType reflect.Type, overrides []any) (map[int]reflect.Value, error) {
+	overridden := make(map[int]reflect.Value, len(overrides))
+	for _, override := range overrides {
+		if override == nil {
+			return nil, fmt.Errorf("%w: override is nil", ErrInvalidValue)
+		}
+		value := reflect.ValueOf(override)
+		index := -1
+		for i := 0; i < fnType.NumIn() && index == -1; i++ {
+			if _, ok := overridden[i]; !ok && fnType.In(i) == value.Type() {
+				index = i
+			}
+		}
+		for i := 0; i < fnType.NumIn() && index == -1; i++ {
+			if _, ok := overridden[i]; !ok && value.Type().AssignableTo(fnType.In(i)) {
+				index = i
+			}
+		}
+		if index == -1 {
+			return nil, fmt.Errorf("%w: override %s matches no argument of %s", ErrInvalidValue, value.Type(), fnType)
+		}
+		converted := reflect.New(fnType.In(index)).Elem()
+		converted.Set(value)
+		overridden[index] = converted
+	}
+	return overridden, nil
+}
+
+// Resolves the arguments of the function type at the indices of the given names with the
+// bindings of those names and adds them to overridden. If a name is not provided an error
+// wrapping ErrNoProvider is returned and if an index is not an argument an error wrapping
+// ErrInvalidValue is returned.
+func (scope *Scope) nameArgs(fnType reflect.Type, names map[int]string, overridden map[int]reflect.Value) error {
+	for index, name := range names {
+		if index < 0 || index >= fnType.NumIn() {
+			return fmt.Errorf("%w: argument %d named %s is not an argument of %s", ErrInvalidValue, index, name, fnType)
+		}
+		argType := fnType.In(index)
+		typ := argType
+		if typ.Kind() == reflect.Pointer {
+			typ = typ.Elem()
+		}
+		instance, err := scope.get(binding{typ: typ, name: name})
+		if err == ErrNoProvider {
+			return fmt.Errorf("%w: argument %d %s named %s", ErrNoProvider, index, argType, name)
+		}
+		if err != nil {
+			return err
+		}
+		value := reflect.ValueOf(instance)
+		if argType.Kind() != reflect.Pointer {
+			value = value.Elem()
+		}
+		overridden[index] = value
+	}
+	return nil
+}
+
+// Returns the arguments to pass to a function of the given type. Arguments which are overridden
+// are not resolved. If strict and any arguments are not provided an error wrapping ErrNoProvider
+// is returned listing every missing argument. If an argument fails to resolve an
+// *InvokeArgError is returned.
+func (scope *Scope) resolveArgs(fnType reflect.Type, strict bool, overridden map[int]reflect.Value) ([]reflect.Value, error) {
 	n := fnType.NumIn()
 	args := make([]reflect.Value, n)
+	missing := []string{}
+	nilPointers := []string{}
+	variadic := fnType.IsVariadic()
 	for i := 0; i < n; i++ {
-		argValue, err := scope.hydrateType(fnType.In(i))
-		if err != nil {
-			return nil, err
+		if override, ok := overridden[i]; ok {
+			args[i] = override
+			continue
+		}
+		argType := fnType.In(i)
+		var argValue reflect.Value
+		var err error
+		if variadic && i == n-1 {
+			argValue, err = scope.hydrateVariadic(argType)
+		} else {
+			argValue, err = scope.hydrateType(argType)
+		}
+		if err == ErrNoProvider {
+			missing = append(missing, fmt.Sprintf("argument %d %s", i, argType))
+		} else if err != nil {
+			return nil, &InvokeArgError{Index: i, Type: argType, Err: err}
 		}
 		if !argValue.IsValid() {
-			return nil, ErrInvalidValue
+			return nil, &InvokeArgError{Index: i, Type: argType, Err: ErrInvalidValue}
+		}
+		if argType.Kind() == reflect.Pointer && argValue.IsNil() {
+			nilPointers = append(nilPointers, fmt.Sprintf("argument %d %s", i, argType))
 		}
 		args[i] = argValue
 	}
+	if strict && len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoProvider, strings.Join(missing, ", "))
+	}
+	if scope.RejectNilPointers && len(nilPointers) > 0 {
+		return nil, fmt.Errorf("%w: nil %s", ErrNoProvider, strings.Join(nilPointers, ", "))
+	}
+	return args, nil
+}
 
-	resultsReflect := fnValue.Call(args)
+// Calls the function with the given arguments, passing the last argument as the variadic
+// slice if the function is variadic.
+func call(fnValue reflect.Value, args []reflect.Value) []reflect.Value {
+	if fnValue.Type().IsVariadic() {
+		return fnValue.CallSlice(args)
+	}
+	return fnValue.Call(args)
+}
 
-	for i := 0; i < n; i++ {
-		argValue := args[i]
-		if argValue.Kind() == reflect.Pointer {
-			key := argValue.Type().Elem()
-			link := scope.providers[key]
-			if link != nil {
-				err := link.afterPointerUse(scope)
-				if err != nil {
-					return nil, err
-				}
-			}
+// Calls the function like call but returns a panic as a *PanicError.
+func callSafe(fnValue reflect.Value, args []reflect.Value) (results []reflect.Value, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = &PanicError{Value: recovered, Stack: debug.Stack()}
 		}
-	}
+	}()
+	return call(fnValue, args), nil
+}
 
-	scope.FreeOnce()
+// The error returned by InvokeSafe when the invoked function panics.
+type PanicError struct {
+	// The value the function panicked with.
+	Value any
+	// The stack of the panic.
+	Stack []byte
+}
 
-	results := make([]any, len(resultsReflect))
-	for i := 0; i < len(results); i++ {
-		results[i] = resultsReflect[i].Interface()
+var _ error = &PanicError{}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("invoked function panicked: %v", e.Value)
+}
+
+// Returns the value the function panicked with if it's an error.
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
 	}
-	return Result(results), nil
+	return nil
 }
 
 type Result []any
@@ -518,6 +3955,29 @@ func (r Result) Err() error {
 	return nil
 }
 
+// Returns all non-nil errors in the result in order.
+func (r Result) Errs() []error {
+	errs := []error{}
+	for _, result := range r {
+		if IsNil(result) {
+			continue
+		}
+		if err, ok := result.(error); ok {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// Returns all non-nil errors in the result combined into one error, or nil if there are none.
+func (r Result) CombinedErr() error {
+	errs := r.Errs()
+	if len(errs) == 0 {
+		return nil
+	}
+	return multiError{errors: errs}
+}
+
 // Returns the non-nil values in the result.
 func (r Result) Defined() []any {
 	nonNil := make([]any, 0, len(r))
@@ -530,6 +3990,76 @@ func (r Result) Defined() []any {
 	return nonNil
 }
 
+// Returns the result at the given index as V. If the index is out of range or the result
+// is not a V an error wrapping ErrInvalidResult is returned. A nil result returns the zero
+// value of V.
+func ResultAt[V any](r Result, index int) (V, error) {
+	var value V
+	if index < 0 || index >= len(r) {
+		return value, fmt.Errorf("%w: index %d out of range for %d results", ErrInvalidResult, index, len(r))
+	}
+	if r[index] == nil {
+		return value, nil
+	}
+	value, ok := r[index].(V)
+	if !ok {
+		return value, fmt.Errorf("%w: result %d is %T not %s", ErrInvalidResult, index, r[index], TypeOf[V]())
+	}
+	return value, nil
+}
+
+// Returns the first non-nil result which is a V.
+func FirstOf[V any](r Result) (V, bool) {
+	for _, result := range r {
+		if IsNil(result) {
+			continue
+		}
+		if value, ok := result.(V); ok {
+			return value, true
+		}
+	}
+	var value V
+	return value, false
+}
+
+// The error returned when a provider fails to create a value. It records the type which
+// failed and the depth of the scope it was created in, where the global scope is zero.
+type CreateError struct {
+	Type  reflect.Type
+	Depth int
+	Err   error
+}
+
+var _ error = &CreateError{}
+
+func (e *CreateError) Error() string {
+	return fmt.Sprintf("creating %s: %v", e.Type, e.Err)
+}
+
+// Returns the error returned by the provider.
+func (e *CreateError) Unwrap() error {
+	return e.Err
+}
+
+// The error returned when an argument of an invoked function could not be resolved. It
+// records the index and type of the argument and the error resolving it.
+type InvokeArgError struct {
+	Index int
+	Type  reflect.Type
+	Err   error
+}
+
+var _ error = &InvokeArgError{}
+
+func (e *InvokeArgError) Error() string {
+	return fmt.Sprintf("argument %d %s: %v", e.Index, e.Type, e.Err)
+}
+
+// Returns the error resolving the argument.
+func (e *InvokeArgError) Unwrap() error {
+	return e.Err
+}
+
 type multiError struct {
 	errors []error
 }
@@ -549,12 +4079,18 @@ func (e multiError) Error() string {
 	}
 }
 
+// Returns the combined errors so errors.Is and errors.As check each of them.
+func (e multiError) Unwrap() []error {
+	return e.errors
+}
+
+// Returns whether the value is nil or holds a nil pointer, map, channel, slice, or function.
 func IsNil(i any) bool {
 	if i == nil {
 		return true
 	}
 	switch reflect.TypeOf(i).Kind() {
-	case reflect.Ptr, reflect.Map, reflect.Array, reflect.Chan, reflect.Slice:
+	case reflect.Ptr, reflect.Map, reflect.Chan, reflect.Slice, reflect.Func, reflect.UnsafePointer:
 		return reflect.ValueOf(i).IsNil()
 	}
 	return false
@@ -1,9 +1,19 @@
 package deps
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"weak"
 )
 
 func TestSetFunc(t *testing.T) {
@@ -314,3 +324,4045 @@ func TestDynamicValue(t *testing.T) {
 		}
 	})
 }
+
+func TestConcurrentGet(t *testing.T) {
+	type Port int
+
+	created := int32(0)
+
+	s := New()
+	ProvideScoped(s, Provider[Port]{
+		Create: func(scope *Scope) (*Port, error) {
+			atomic.AddInt32(&created, 1)
+			port := Port(8080)
+			return &port, nil
+		},
+	})
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, err := GetScoped[Port](s)
+			if err != nil || *p != 8080 {
+				t.Errorf("Concurrent get failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created != 1 {
+		t.Errorf("Create was called %d times, expected once", created)
+	}
+}
+
+func TestConcurrentSiblingCreate(t *testing.T) {
+	type Session struct{}
+
+	started := sync.WaitGroup{}
+	started.Add(2)
+
+	s := New()
+	ProvideScoped(s, Provider[Session]{
+		Lifetime: LifetimeScope,
+		Create: func(scope *Scope) (*Session, error) {
+			started.Done()
+			done := make(chan struct{})
+			go func() {
+				started.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+				return &Session{}, nil
+			case <-time.After(time.Second):
+				return nil, errors.New("sibling scopes did not create at the same time")
+			}
+		},
+	})
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := GetScoped[Session](s.Spawn()); err != nil {
+				t.Errorf("Sibling scopes should create their values in parallel: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCircularDependency(t *testing.T) {
+	type A int
+	type B int
+
+	s := New()
+	ProvideScoped(s, Provider[A]{
+		Create: func(scope *Scope) (*A, error) {
+			_, err := GetScoped[B](scope)
+			return nil, err
+		},
+	})
+	ProvideScoped(s, Provider[B]{
+		Create: func(scope *Scope) (*B, error) {
+			_, err := GetScoped[A](scope)
+			return nil, err
+		},
+	})
+
+	_, err := GetScoped[A](s)
+	if !errors.Is(err, ErrCircularDependency) {
+		t.Fatalf("Expected circular dependency error, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "deps.A -> deps.B -> deps.A") {
+		t.Errorf("Circular dependency error does not describe cycle: %v", err)
+	}
+}
+
+func TestCircularDependencyThree(t *testing.T) {
+	type A int
+	type B int
+	type C int
+
+	s := New()
+	ProvideScoped(s, Provider[A]{
+		Create: func(scope *Scope) (*A, error) {
+			_, err := GetScoped[B](scope)
+			return nil, err
+		},
+	})
+	ProvideScoped(s, Provider[B]{
+		Create: func(scope *Scope) (*B, error) {
+			_, err := GetScoped[C](scope)
+			return nil, err
+		},
+	})
+	ProvideScoped(s, Provider[C]{
+		Create: func(scope *Scope) (*C, error) {
+			_, err := GetScoped[A](scope)
+			return nil, err
+		},
+	})
+
+	_, err := s.Invoke(func(c C) {})
+	if !errors.Is(err, ErrCircularDependency) {
+		t.Fatalf("Expected circular dependency error, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "deps.C -> deps.A -> deps.B -> deps.C") {
+		t.Errorf("Circular dependency error does not describe cycle: %v", err)
+	}
+}
+
+func TestNamed(t *testing.T) {
+	type DB struct{ Host string }
+	type Env struct {
+		Primary   *DB `deps:"primary"`
+		Replica   *DB `deps:"replica"`
+		Analytics *DB `deps:"analytics"`
+		Default   DB
+	}
+
+	s := New()
+	ProvideNamed(s, "primary", Provider[*DB]{
+		Create: func(scope *Scope) (**DB, error) {
+			db := &DB{Host: "primary"}
+			return &db, nil
+		},
+	})
+	ProvideNamed(s, "replica", Provider[*DB]{
+		Create: func(scope *Scope) (**DB, error) {
+			db := &DB{Host: "replica"}
+			return &db, nil
+		},
+	})
+	ProvideNamed(s, "analytics", Provider[DB]{
+		Create: func(scope *Scope) (*DB, error) {
+			return &DB{Host: "analytics"}, nil
+		},
+	})
+	ProvideScoped(s, Provider[DB]{
+		Create: func(scope *Scope) (*DB, error) {
+			return &DB{Host: "default"}, nil
+		},
+	})
+
+	replica, _ := GetNamed[*DB](s, "replica")
+	if replica == nil || (*replica).Host != "replica" {
+		t.Errorf("GetNamed failed to return replica")
+	}
+
+	_, err := GetNamed[*DB](s, "missing")
+	if err != ErrNoProvider {
+		t.Errorf("GetNamed should not resolve a missing name: %v", err)
+	}
+
+	env := Env{}
+	err = s.Hydrate(&env)
+	if err != nil {
+		t.Fatalf("Hydrate failed: %v", err)
+	}
+	if env.Primary == nil || env.Primary.Host != "primary" {
+		t.Errorf("Hydrate failed to set primary")
+	}
+	if env.Replica == nil || env.Replica.Host != "replica" {
+		t.Errorf("Hydrate failed to set replica")
+	}
+	if env.Analytics == nil || env.Analytics.Host != "analytics" {
+		t.Errorf("Hydrate should set named pointers to the value of the named provider of the type they point to")
+	}
+	if env.Default.Host != "default" {
+		t.Errorf("Hydrate failed to set unnamed value")
+	}
+}
+
+func TestInvokeContext(t *testing.T) {
+	type ctxKey struct{}
+	type RequestID string
+
+	s := New()
+	ProvideScoped(s, Provider[RequestID]{
+		Lifetime: LifetimeOnce,
+		Create: func(scope *Scope) (*RequestID, error) {
+			id := RequestID(scope.Context().Value(ctxKey{}).(string))
+			return &id, nil
+		},
+	})
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "abc")
+
+	_, err := s.InvokeContext(ctx, func(given context.Context, id RequestID) {
+		if given != ctx {
+			t.Errorf("Invoked function was not given the context")
+		}
+		if id != "abc" {
+			t.Errorf("Provider was not given the context: %v", id)
+		}
+	})
+	if err != nil {
+		t.Fatalf("InvokeContext failed: %v", err)
+	}
+
+	if s.Context() != context.Background() {
+		t.Errorf("Context leaked into the scope after invoke")
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	type A int
+	type B int
+	type C int
+
+	created := []string{}
+
+	s := New()
+	ProvideScoped(s, Provider[A]{
+		Create: func(scope *Scope) (*A, error) {
+			created = append(created, "A")
+			a := A(1)
+			return &a, nil
+		},
+	})
+	ProvideScoped(s, Provider[B]{
+		Lifetime: LifetimeScope,
+		Create: func(scope *Scope) (*B, error) {
+			a, err := GetScoped[A](scope)
+			if err != nil {
+				return nil, err
+			}
+			created = append(created, "B")
+			b := B(*a + 1)
+			return &b, nil
+		},
+	})
+	ProvideScoped(s, Provider[C]{
+		Create: func(scope *Scope) (*C, error) {
+			created = append(created, "C")
+			c := C(3)
+			return &c, nil
+		},
+	})
+
+	err := s.Warmup()
+	if err != nil {
+		t.Fatalf("Warmup failed: %v", err)
+	}
+	if len(created) != 3 {
+		t.Errorf("Warmup should have created 3 values: %v", created)
+	}
+
+	s.Warmup()
+	if len(created) != 3 {
+		t.Errorf("Warmup should not create values twice: %v", created)
+	}
+
+	defer SetGlobal(nil)()
+
+	type Singleton int
+	type Transient int
+
+	child := New().Spawn()
+	ProvideScoped(child, Provider[Singleton]{
+		Lifetime: LifetimeSingleton,
+		Create: func(scope *Scope) (*Singleton, error) {
+			singleton := Singleton(1)
+			return &singleton, nil
+		},
+	})
+	transients := 0
+	ProvideScoped(child, Provider[Transient]{
+		Lifetime: LifetimeTransient,
+		Create: func(scope *Scope) (*Transient, error) {
+			transients++
+			transient := Transient(1)
+			return &transient, nil
+		},
+	})
+	if err := child.Warmup(); err != nil {
+		t.Fatalf("Warmup failed: %v", err)
+	}
+	if _, exists := Global().getInstance(binding{typ: TypeOf[Singleton]()}); !exists {
+		t.Errorf("Warmup should store singletons on the global scope")
+	}
+	if _, exists := child.getInstance(binding{typ: TypeOf[Singleton]()}); exists {
+		t.Errorf("Warmup should not store singletons on the scope")
+	}
+	if transients != 0 {
+		t.Errorf("Warmup should not create transient values: %d", transients)
+	}
+}
+
+func TestOverride(t *testing.T) {
+	type Database struct{ Name string }
+
+	real := Provider[Database]{
+		Create: func(scope *Scope) (*Database, error) {
+			return &Database{Name: "real"}, nil
+		},
+	}
+	Provide(real)
+	defer Global().swapProvider(binding{typ: TypeOf[Database]()}, nil)
+
+	s := New()
+
+	restore := Override(s, Provider[Database]{
+		Create: func(scope *Scope) (*Database, error) {
+			return &Database{Name: "fake"}, nil
+		},
+	})
+
+	db, _ := GetScoped[Database](s)
+	if db == nil || db.Name != "fake" {
+		t.Errorf("Override did not shadow global provider: %v", db)
+	}
+
+	restore()
+
+	db, _ = GetScoped[Database](s)
+	if db == nil || db.Name != "real" {
+		t.Errorf("Restore did not return to global provider: %v", db)
+	}
+}
+
+func TestInvokeStrict(t *testing.T) {
+	type Port int
+	type Host string
+	type Database struct{}
+
+	port := Port(8080)
+	s := New()
+	s.Set(&port)
+
+	invoked := false
+	_, err := s.InvokeStrict(func(p Port, h Host, db *Database) {
+		invoked = true
+	})
+
+	if invoked {
+		t.Errorf("InvokeStrict should not call the function with missing arguments")
+	}
+	if !errors.Is(err, ErrNoProvider) {
+		t.Fatalf("InvokeStrict should return ErrNoProvider: %v", err)
+	}
+	if !strings.Contains(err.Error(), "argument 1 deps.Host") || !strings.Contains(err.Error(), "argument 2 *deps.Database") {
+		t.Errorf("InvokeStrict error should list every missing argument: %v", err)
+	}
+
+	_, err = s.InvokeStrict(func(p Port) {
+		invoked = true
+	})
+	if err != nil || !invoked {
+		t.Errorf("InvokeStrict failed with provided arguments: %v", err)
+	}
+}
+
+func TestInvokeVariadic(t *testing.T) {
+	type Port int
+
+	s := New()
+
+	given := []Port{}
+	invoke := func(ports ...Port) {
+		given = ports
+	}
+
+	s.Invoke(invoke)
+	if len(given) != 0 {
+		t.Errorf("Variadic invoke without provider should be empty: %v", given)
+	}
+
+	s.Set(Port(8080))
+	s.Invoke(invoke)
+	if len(given) != 1 || given[0] != 8080 {
+		t.Errorf("Variadic invoke should be given provided value: %v", given)
+	}
+
+	s.Set([]Port{80, 443})
+	s.Invoke(invoke)
+	if len(given) != 2 || given[0] != 80 || given[1] != 443 {
+		t.Errorf("Variadic invoke should be given provided slice: %v", given)
+	}
+
+	type Option struct {
+		Port Port
+	}
+
+	options := []Option{}
+	invokeOptions := func(opts ...Option) {
+		options = opts
+	}
+
+	New().Invoke(invokeOptions)
+	if len(options) != 0 {
+		t.Errorf("Variadic struct invoke without provider should be empty: %v", options)
+	}
+
+	s.Set(Option{Port: 80})
+	s.Invoke(invokeOptions)
+	if len(options) != 1 || options[0].Port != 80 {
+		t.Errorf("Variadic struct invoke should be given provided value: %v", options)
+	}
+}
+
+type Handler interface {
+	Handle() string
+}
+type namedHandler string
+
+func (h namedHandler) Handle() string {
+	return string(h)
+}
+
+func TestGroup(t *testing.T) {
+	freed := []string{}
+	member := func(name string, lifetime Lifetime) Provider[Handler] {
+		return Provider[Handler]{
+			Lifetime: lifetime,
+			Create: func(scope *Scope) (*Handler, error) {
+				var h Handler = namedHandler(name)
+				return &h, nil
+			},
+			Free: func(scope *Scope, value *Handler) error {
+				freed = append(freed, (*value).Handle())
+				return nil
+			},
+		}
+	}
+
+	parent := New()
+	ProvideGroupScoped(parent, member("a", LifetimeForever))
+	s := parent.Spawn()
+	ProvideGroupScoped(s, member("b", LifetimeScope))
+	ProvideGroupScoped(s, member("c", LifetimeForever))
+
+	handlers, err := GetGroupScoped[Handler](s)
+	if err != nil {
+		t.Fatalf("GetGroupScoped failed: %v", err)
+	}
+	if len(handlers) != 3 || handlers[0].Handle() != "a" || handlers[1].Handle() != "b" || handlers[2].Handle() != "c" {
+		t.Errorf("GetGroupScoped returned the wrong handlers: %v", handlers)
+	}
+
+	given := []Handler{}
+	s.Invoke(func(hs []Handler) {
+		given = hs
+	})
+	if len(given) != 3 {
+		t.Errorf("Invoke was not given the group: %v", given)
+	}
+
+	s.Free()
+	if len(freed) != 2 {
+		t.Errorf("Free should free the members on the scope: %v", freed)
+	}
+	parent.Free()
+	if len(freed) != 3 {
+		t.Errorf("Free should free the members on the parent: %v", freed)
+	}
+}
+
+func TestDecorator(t *testing.T) {
+	type Greeting string
+
+	created := 0
+
+	s := New()
+	ProvideScoped(s, Provider[Greeting]{
+		Create: func(scope *Scope) (*Greeting, error) {
+			created++
+			g := Greeting("hello")
+			return &g, nil
+		},
+	})
+	ProvideDecorator(s, func(scope *Scope, value *Greeting) (*Greeting, error) {
+		*value += " world"
+		return value, nil
+	})
+	ProvideDecorator(s, func(scope *Scope, value *Greeting) (*Greeting, error) {
+		g := *value + "!"
+		return &g, nil
+	})
+
+	g, _ := GetScoped[Greeting](s)
+	if g == nil || *g != "hello world!" {
+		t.Errorf("Decorators were not applied in order: %v", g)
+	}
+
+	again, _ := GetScoped[Greeting](s)
+	if again != g || created != 1 {
+		t.Errorf("Decorated value should be cached")
+	}
+}
+
+func TestFreeOrder(t *testing.T) {
+	type A int
+	type B int
+
+	freed := []string{}
+
+	s := New()
+	ProvideScoped(s, Provider[A]{
+		Create: func(scope *Scope) (*A, error) {
+			a := A(1)
+			return &a, nil
+		},
+		Free: func(scope *Scope, value *A) error {
+			freed = append(freed, "A")
+			return nil
+		},
+	})
+	ProvideScoped(s, Provider[B]{
+		Create: func(scope *Scope) (*B, error) {
+			a, err := GetScoped[A](scope)
+			if err != nil {
+				return nil, err
+			}
+			b := B(*a)
+			return &b, nil
+		},
+		Free: func(scope *Scope, value *B) error {
+			freed = append(freed, "B")
+			return nil
+		},
+	})
+
+	GetScoped[B](s)
+	s.Free()
+
+	if len(freed) != 2 || freed[0] != "B" || freed[1] != "A" {
+		t.Errorf("Values were not freed in reverse creation order: %v", freed)
+	}
+}
+
+func TestHydrateUnexported(t *testing.T) {
+	type Port int
+	type Config struct {
+		port Port
+	}
+
+	s := New()
+	s.Set(Port(8080))
+
+	config := Config{}
+	s.Hydrate(&config)
+	if config.port != 0 {
+		t.Errorf("Unexported fields should not be hydrated by default")
+	}
+
+	s.HydrateUnexported = true
+	s.Hydrate(&config)
+	if config.port != 8080 {
+		t.Errorf("Unexported fields should be hydrated when enabled")
+	}
+}
+
+func TestProvideFunc(t *testing.T) {
+	type Config struct{ Host string }
+	type DB struct{ Host string }
+	type Cache struct{ DB *DB }
+
+	s := New()
+	s.Set(&Config{Host: "localhost"})
+
+	err := ProvideFunc(s, func(cfg *Config) (*DB, error) {
+		return &DB{Host: cfg.Host}, nil
+	})
+	if err != nil {
+		t.Fatalf("ProvideFunc failed: %v", err)
+	}
+	err = ProvideFunc(s, func(db *DB) *Cache {
+		return &Cache{DB: db}
+	})
+	if err != nil {
+		t.Fatalf("ProvideFunc without error failed: %v", err)
+	}
+
+	cache, err := GetScoped[Cache](s)
+	if err != nil || cache.DB == nil || cache.DB.Host != "localhost" {
+		t.Errorf("ProvideFunc constructors were not resolved: %v", err)
+	}
+
+	err = ProvideFunc(s, func() DB {
+		return DB{}
+	})
+	if !errors.Is(err, ErrInvalidConstructor) {
+		t.Errorf("ProvideFunc should reject constructors which don't return pointers: %v", err)
+	}
+
+	failure := errors.New("failed")
+	ProvideFunc(s, func() (*int, error) {
+		return nil, failure
+	})
+	_, err = GetScoped[int](s)
+	if !errors.Is(err, failure) {
+		t.Errorf("ProvideFunc should return the constructor error: %v", err)
+	}
+}
+
+func TestCanResolve(t *testing.T) {
+	type Database struct{}
+	type Missing struct{}
+
+	created := false
+
+	s := New()
+	ProvideScoped(s, Provider[Database]{
+		Create: func(scope *Scope) (*Database, error) {
+			created = true
+			return &Database{}, nil
+		},
+	})
+
+	if !CanResolve[Database](s) {
+		t.Errorf("CanResolve should be true for a provided type")
+	}
+	if !CanResolve[Database](s.Spawn()) {
+		t.Errorf("CanResolve should be true for a type provided on a parent")
+	}
+	if created {
+		t.Errorf("CanResolve should not create values")
+	}
+	if CanResolve[Missing](s) {
+		t.Errorf("CanResolve should be false for a type which is not provided")
+	}
+	if !CanResolve[Gen[int]](s) {
+		t.Errorf("CanResolve should be true for Dynamic types")
+	}
+
+	called := 0
+	dynamic := New()
+	dynamic.Dynamic = func(typ reflect.Type, scope *Scope) (any, error) {
+		called++
+		return &Database{}, nil
+	}
+	if !CanResolve[Database](dynamic) || !CanResolve[Database](dynamic.Spawn()) || called != 0 {
+		t.Errorf("CanResolve should be true with a Dynamic func without calling it: %d", called)
+	}
+	if err := dynamic.DryRun(func(db *Database) {}); err != nil || called != 0 {
+		t.Errorf("DryRun should not call Dynamic funcs: %v %d", err, called)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	type Database struct{}
+	type Port int
+
+	parent := New()
+	parent.Require(TypeOf[Database]())
+	s := parent.Spawn()
+
+	invoked := false
+	_, err := s.Invoke(func(port Port, db *Database) {
+		invoked = true
+	})
+	if invoked || !errors.Is(err, ErrNoProvider) {
+		t.Errorf("Invoke should fail when a required type is missing: %v", err)
+	}
+
+	s.Set(&Database{})
+	_, err = s.Invoke(func(port Port, db *Database) {
+		invoked = true
+	})
+	if !invoked || err != nil {
+		t.Errorf("Invoke should succeed when only optional types are missing: %v", err)
+	}
+}
+
+func TestGraph(t *testing.T) {
+	type Config struct{ Host string }
+	type DB struct{ Host string }
+	type Service struct{}
+
+	s := New()
+	ProvideScoped(s, Provider[Config]{
+		Create: func(scope *Scope) (*Config, error) {
+			return &Config{Host: "localhost"}, nil
+		},
+	})
+	ProvideScoped(s, Provider[DB]{
+		Lifetime: LifetimeScope,
+		Create: func(scope *Scope) (*DB, error) {
+			config, err := GetScoped[Config](scope)
+			if err != nil {
+				return nil, err
+			}
+			return &DB{Host: config.Host}, nil
+		},
+	})
+	ProvideFunc(s, func(db *DB, config *Config) *Service {
+		return &Service{}
+	})
+
+	edges := s.Graph()
+	if len(edges) != 3 {
+		t.Fatalf("Graph should have 3 nodes: %+v", edges)
+	}
+
+	expected := []GraphEdge{
+		{Type: "deps.Config", Lifetime: LifetimeForever, Dependencies: []string{}},
+		{Type: "deps.DB", Lifetime: LifetimeScope, Dependencies: []string{"deps.Config"}},
+		{Type: "deps.Service", Lifetime: LifetimeForever, Dependencies: []string{"deps.DB", "deps.Config"}},
+	}
+	if !reflect.DeepEqual(edges, expected) {
+		t.Errorf("Graph returned unexpected edges: %+v", edges)
+	}
+
+	if _, exists := s.getInstance(binding{typ: TypeOf[Config]()}); exists {
+		t.Errorf("Graph should not store created values")
+	}
+}
+
+func TestLazy(t *testing.T) {
+	type Parent struct{ Child Lazy[Handler] }
+
+	created := 0
+
+	s := New()
+	ProvideScoped(s, Provider[Handler]{
+		Create: func(scope *Scope) (*Handler, error) {
+			created++
+			var h Handler = namedHandler("child")
+			return &h, nil
+		},
+	})
+	ProvideFunc(s, func(child Lazy[Handler]) *Parent {
+		return &Parent{Child: child}
+	})
+
+	parent, err := GetScoped[Parent](s)
+	if err != nil {
+		t.Fatalf("Failed to create parent: %v", err)
+	}
+	if created != 0 {
+		t.Errorf("Lazy value should not be created until used")
+	}
+
+	child, err := parent.Child.Get()
+	if err != nil || (*child).Handle() != "child" {
+		t.Errorf("Lazy value was not resolved: %v", err)
+	}
+	parent.Child.Get()
+	if created != 1 {
+		t.Errorf("Lazy value should be resolved once: %d", created)
+	}
+
+	hydrated := struct{ Child Lazy[Handler] }{}
+	s.Hydrate(&hydrated)
+	if child, _ := hydrated.Child.Get(); child == nil {
+		t.Errorf("Hydrate should give a lazy handle")
+	}
+}
+
+type lazyA struct {
+	B Lazy[lazyB]
+}
+
+type lazyB struct {
+	A *lazyA
+}
+
+func TestLazyMutual(t *testing.T) {
+	s := New()
+	ProvideScoped(s, Provider[lazyA]{
+		Lifetime: LifetimeTransient,
+		Create: func(scope *Scope) (*lazyA, error) {
+			a := &lazyA{}
+			return a, scope.Hydrate(&a.B)
+		},
+	})
+	ProvideScoped(s, Provider[lazyB]{
+		Lifetime: LifetimeTransient,
+		Create: func(scope *Scope) (*lazyB, error) {
+			if scope.Context().Err() != nil {
+				return nil, scope.Context().Err()
+			}
+			a, err := GetScoped[lazyA](scope)
+			return &lazyB{A: a}, err
+		},
+	})
+
+	a, err := GetScoped[lazyA](s)
+	if err != nil {
+		t.Fatalf("Failed to create a: %v", err)
+	}
+	b, err := a.B.Get()
+	if err != nil || b.A == nil {
+		t.Errorf("Lazy values should be resolved outside the resolution chain they were created in: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var handle Lazy[lazyB]
+	s.InvokeContext(ctx, func(b Lazy[lazyB]) {
+		handle = b
+	})
+	cancel()
+	if _, err := handle.Get(); err != nil {
+		t.Errorf("Lazy values should not keep the context they were created in: %v", err)
+	}
+}
+
+func TestSetDefault(t *testing.T) {
+	type Port int
+	type Host string
+
+	parent := New()
+	parent.SetDefault(Port(8080))
+	parent.SetDefault(Host("localhost"))
+	s := parent.Spawn()
+	ProvideScoped(s, Provider[Host]{
+		Create: func(scope *Scope) (*Host, error) {
+			host := Host("example.com")
+			return &host, nil
+		},
+	})
+
+	s.Invoke(func(port Port, host Host, portPtr *Port) {
+		if port != 8080 {
+			t.Errorf("Default value should be used when not provided: %v", port)
+		}
+		if portPtr == nil || *portPtr != 8080 {
+			t.Errorf("Default value should be used for pointers when not provided: %v", portPtr)
+		}
+		if host != "example.com" {
+			t.Errorf("Provided value should take priority over default: %v", host)
+		}
+	})
+}
+
+func TestClone(t *testing.T) {
+	type Port int
+	type Session struct{ ID int }
+
+	sessions := 0
+
+	s := New()
+	s.Set(Port(8080))
+	ProvideScoped(s, Provider[Session]{
+		Lifetime: LifetimeScope,
+		Create: func(scope *Scope) (*Session, error) {
+			sessions++
+			return &Session{ID: sessions}, nil
+		},
+	})
+	original, _ := GetScoped[Session](s)
+
+	clone := s.Clone()
+	if clone.Parent() != Global() {
+		t.Errorf("Clone should reference the same parent")
+	}
+	clone.Set(Port(4040))
+	if port, _ := GetScoped[Port](s); *port != 8080 {
+		t.Errorf("Setting a value on the clone should not affect the original: %v", *port)
+	}
+	if session, _ := GetScoped[Session](clone); session != original {
+		t.Errorf("Clone should share created values")
+	}
+
+	deep, err := s.DeepClone()
+	if err != nil {
+		t.Fatalf("DeepClone failed: %v", err)
+	}
+	if session, _ := GetScoped[Session](deep); session == original || session.ID != 2 {
+		t.Errorf("DeepClone should create scope values again: %v", session)
+	}
+
+	if Global().Clone().Parent() != Global() {
+		t.Errorf("Cloning the global scope should return a child of it")
+	}
+}
+
+func TestFreeTree(t *testing.T) {
+	type Connection struct{}
+
+	freed := 0
+
+	s := New()
+	ProvideScoped(s, Provider[Connection]{
+		Lifetime: LifetimeScope,
+		Create: func(scope *Scope) (*Connection, error) {
+			return &Connection{}, nil
+		},
+		Free: func(scope *Scope, value *Connection) error {
+			freed++
+			return nil
+		},
+	})
+
+	child := s.Spawn()
+	grandchild := child.Spawn()
+	GetScoped[Connection](child)
+	GetScoped[Connection](grandchild)
+
+	s.Free()
+	if freed != 0 {
+		t.Errorf("Free should not free child scopes")
+	}
+
+	childEvents := 0
+	child.OnEvent = func(event Event) {
+		if event.Kind == EventFreed {
+			childEvents++
+		}
+	}
+	s.FreeTree()
+	if freed != 2 {
+		t.Errorf("FreeTree should free child scopes: %d", freed)
+	}
+	if childEvents != 2 {
+		t.Errorf("FreeTree should free child scopes with their own settings: %d", childEvents)
+	}
+
+	runtime.KeepAlive(grandchild)
+
+	dropped := New()
+	func() {
+		for i := 0; i < 10; i++ {
+			spawned := dropped.Spawn()
+			ProvideScoped(spawned, Provider[Connection]{
+				Lifetime: LifetimeScope,
+				Create: func(scope *Scope) (*Connection, error) {
+					return &Connection{}, nil
+				},
+			})
+			GetScoped[Connection](spawned)
+		}
+	}()
+	tracked := func() int {
+		dropped.mutex.RLock()
+		defer dropped.mutex.RUnlock()
+		return len(dropped.children)
+	}
+	for i := 0; i < 50 && tracked() > 0; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if tracked() != 0 {
+		t.Errorf("Dropped child scopes should be forgotten once they're collected: %d", tracked())
+	}
+}
+
+func TestResultHelpers(t *testing.T) {
+	s := New()
+	r, _ := s.Invoke(func() (int, string, int, error) {
+		return 1, "two", 3, nil
+	})
+
+	if first, ok := FirstOf[int](r); !ok || first != 1 {
+		t.Errorf("FirstOf returned the wrong int: %v", first)
+	}
+	if str, ok := FirstOf[string](r); !ok || str != "two" {
+		t.Errorf("FirstOf returned the wrong string: %v", str)
+	}
+	if _, ok := FirstOf[error](r); ok {
+		t.Errorf("FirstOf should skip nil results")
+	}
+	if third, err := ResultAt[int](r, 2); err != nil || third != 3 {
+		t.Errorf("ResultAt returned the wrong int: %v %v", third, err)
+	}
+	if _, err := ResultAt[int](r, 1); !errors.Is(err, ErrInvalidResult) {
+		t.Errorf("ResultAt should fail for the wrong type: %v", err)
+	}
+	if _, err := ResultAt[int](r, 4); !errors.Is(err, ErrInvalidResult) {
+		t.Errorf("ResultAt should fail for an invalid index: %v", err)
+	}
+	if err, _ := ResultAt[error](r, 3); err != nil {
+		t.Errorf("ResultAt should return nil results: %v", err)
+	}
+}
+
+func TestAfterUseParent(t *testing.T) {
+	type Preferences struct{ Name string }
+
+	var afterUse *Preferences
+
+	Provide(Provider[Preferences]{
+		Create: func(scope *Scope) (*Preferences, error) {
+			return &Preferences{}, nil
+		},
+		AfterPointerUse: func(scope *Scope, value *Preferences) error {
+			afterUse = value
+			return nil
+		},
+	})
+	defer Global().swapProvider(binding{typ: TypeOf[Preferences]()}, nil)
+
+	s := New().Spawn()
+	s.Invoke(func(p *Preferences) {
+		p.Name = "changed"
+	})
+
+	if afterUse == nil || afterUse.Name != "changed" {
+		t.Errorf("After use was not called for a provider on the global scope")
+	}
+}
+
+func TestBeforeUse(t *testing.T) {
+	type Port int
+
+	var beforeUse Port
+	locked := errors.New("locked")
+
+	s := New()
+	ProvideScoped(s, Provider[Port]{
+		Create: func(scope *Scope) (*Port, error) {
+			port := Port(8080)
+			return &port, nil
+		},
+		BeforePointerUse: func(scope *Scope, value *Port) error {
+			if beforeUse != 0 {
+				return locked
+			}
+			beforeUse = *value
+			return nil
+		},
+	})
+
+	s.Invoke(func(p *Port) {
+		if beforeUse != Port(8080) {
+			t.Errorf("Before use should be called before the function")
+		}
+		*p = 4040
+	})
+
+	invoked := false
+	_, err := s.Invoke(func(p *Port) {
+		invoked = true
+	})
+	if err != locked || invoked {
+		t.Errorf("Before use error should abort the invoke: %v", err)
+	}
+}
+
+type fileLogger struct{ path string }
+
+func (l *fileLogger) Handle() string {
+	return l.path
+}
+
+type consoleLogger struct{}
+
+func (l consoleLogger) Handle() string {
+	return "console"
+}
+
+func TestResolveByInterface(t *testing.T) {
+	s := New()
+	ProvideScoped(s, Provider[*fileLogger]{
+		Create: func(scope *Scope) (**fileLogger, error) {
+			l := &fileLogger{path: "log.txt"}
+			return &l, nil
+		},
+	})
+	ProvideScoped(s, Provider[fileLogger]{
+		Create: func(scope *Scope) (*fileLogger, error) {
+			return &fileLogger{path: "other.txt"}, nil
+		},
+	})
+
+	_, err := GetScoped[Handler](s)
+	if err != ErrNoProvider {
+		t.Errorf("Interfaces should not be resolved by implementation by default: %v", err)
+	}
+
+	s.ResolveByInterface = true
+	_, err = GetScoped[Handler](s)
+	if !errors.Is(err, ErrAmbiguousProvider) {
+		t.Errorf("Multiple implementations should be ambiguous: %v", err)
+	}
+
+	single := New()
+	single.ResolveByInterface = true
+	ProvideScoped(single, Provider[consoleLogger]{
+		Create: func(scope *Scope) (*consoleLogger, error) {
+			return &consoleLogger{}, nil
+		},
+	})
+	h, err := GetScoped[Handler](single.Spawn())
+	if err != nil || (*h).Handle() != "console" {
+		t.Errorf("Interface should be resolved by implementation on a parent: %v", err)
+	}
+
+	pointer := New()
+	pointer.ResolveByInterface = true
+	ProvideScoped(pointer, Provider[fileLogger]{
+		Create: func(scope *Scope) (*fileLogger, error) {
+			return &fileLogger{path: "log.txt"}, nil
+		},
+	})
+	h, err = GetScoped[Handler](pointer)
+	if err != nil || (*h).Handle() != "log.txt" {
+		t.Errorf("Interface should be resolved by pointer implementation: %v", err)
+	}
+}
+
+func TestCreateTimeout(t *testing.T) {
+	type Connection struct{}
+
+	created := int32(0)
+
+	s := New()
+	ProvideScoped(s, Provider[Connection]{
+		Timeout: 10 * time.Millisecond,
+		Create: func(scope *Scope) (*Connection, error) {
+			if atomic.AddInt32(&created, 1) == 1 {
+				time.Sleep(50 * time.Millisecond)
+			}
+			return &Connection{}, nil
+		},
+	})
+
+	_, err := GetScoped[Connection](s)
+	if !errors.Is(err, ErrCreateTimeout) || !strings.Contains(err.Error(), "deps.Connection") {
+		t.Errorf("Create should time out: %v", err)
+	}
+	if _, exists := s.getInstance(binding{typ: TypeOf[Connection]()}); exists {
+		t.Errorf("Timed out value should not be stored")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	c, err := GetScoped[Connection](s)
+	if err != nil || c == nil {
+		t.Errorf("Create should succeed within the timeout: %v", err)
+	}
+}
+
+func TestSingleton(t *testing.T) {
+	type Registry struct{}
+
+	freed := false
+
+	parent := New()
+	ProvideScoped(parent, Provider[Registry]{
+		Lifetime: LifetimeSingleton,
+		Create: func(scope *Scope) (*Registry, error) {
+			return &Registry{}, nil
+		},
+		Free: func(scope *Scope, value *Registry) error {
+			freed = true
+			return nil
+		},
+	})
+
+	a, _ := GetScoped[Registry](parent.Spawn())
+	b, _ := GetScoped[Registry](parent.Spawn())
+	if a == nil || a != b {
+		t.Errorf("Singleton should be the same value for sibling scopes")
+	}
+
+	key := binding{typ: TypeOf[Registry]()}
+	if instance, _ := Global().getInstance(key); instance != a {
+		t.Errorf("Singleton should be stored on the global scope")
+	}
+
+	parent.FreeTree()
+	if freed {
+		t.Errorf("Singleton should only be freed with the global scope")
+	}
+
+	Global().instanceLink(key).free(Global())
+	if !freed {
+		t.Errorf("Singleton should be freed by its provider")
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	type Config struct{ Version int }
+
+	version := 0
+	freed := 0
+
+	s := New()
+	ProvideScoped(s, Provider[Config]{
+		Create: func(scope *Scope) (*Config, error) {
+			version++
+			return &Config{Version: version}, nil
+		},
+		Free: func(scope *Scope, value *Config) error {
+			freed++
+			return nil
+		},
+	})
+
+	if err := Invalidate[Config](s); err != nil || freed != 0 {
+		t.Errorf("Invalidating a value which isn't cached should do nothing: %v", err)
+	}
+
+	first, _ := GetScoped[Config](s)
+	if err := Invalidate[Config](s); err != nil || freed != 1 {
+		t.Errorf("Invalidate should free the value: %v", err)
+	}
+	second, _ := GetScoped[Config](s)
+	if first.Version != 1 || second.Version != 2 {
+		t.Errorf("Invalidate should cause the value to be created again")
+	}
+}
+
+func TestHydratePointers(t *testing.T) {
+	type Port int
+	type Config struct {
+		Port Port
+	}
+	type Node struct {
+		Port   Port
+		Next   *Node
+		Config **Config
+	}
+
+	s := New()
+	s.Set(Port(8080))
+
+	config := &Config{}
+	node := &Node{Config: &config}
+	node.Next = &Node{Next: node}
+
+	err := s.Hydrate(node)
+	if err != nil {
+		t.Fatalf("Hydrate failed: %v", err)
+	}
+	if node.Port != 8080 || node.Next.Port != 8080 {
+		t.Errorf("Hydrate should follow non-nil pointers")
+	}
+	if config.Port != 8080 {
+		t.Errorf("Hydrate should follow pointers to pointers")
+	}
+}
+
+func TestOnEvent(t *testing.T) {
+	type Config struct{ Version int }
+
+	events := []EventKind{}
+	parent := New()
+	parent.OnEvent = func(event Event) {
+		if event.Type == TypeOf[Config]() {
+			events = append(events, event.Kind)
+		}
+	}
+
+	child := parent.Spawn()
+	ProvideScoped(child, Provider[Config]{
+		Create: func(scope *Scope) (*Config, error) {
+			return &Config{}, nil
+		},
+	})
+
+	GetScoped[Config](child)
+	GetScoped[Config](child)
+	child.Free()
+
+	expected := []EventKind{EventCreated, EventReused, EventFreed}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected events %v from the child scope but got %v", expected, events)
+	}
+}
+
+func TestAlias(t *testing.T) {
+	s := New()
+	ProvideScoped(s, Provider[bytes.Buffer]{
+		Create: func(scope *Scope) (*bytes.Buffer, error) {
+			return &bytes.Buffer{}, nil
+		},
+	})
+	if err := Alias[io.Writer, bytes.Buffer](s); err != nil {
+		t.Fatalf("Alias failed: %v", err)
+	}
+
+	buffer, _ := GetScoped[bytes.Buffer](s)
+	writer, err := GetScoped[io.Writer](s)
+	if err != nil || *writer != io.Writer(buffer) {
+		t.Errorf("Alias should resolve the same buffer: %v", err)
+	}
+
+	if err := Alias[io.Reader, int](s); !errors.Is(err, ErrInvalidAlias) {
+		t.Errorf("Alias to an unassignable type should fail: %v", err)
+	}
+	if err := Alias[io.Writer, io.Writer](New()); !errors.Is(err, ErrInvalidAlias) {
+		t.Errorf("Alias to itself should fail: %v", err)
+	}
+
+	type Writer interface {
+		Write(p []byte) (n int, err error)
+	}
+
+	cycle := New()
+	if err := Alias[io.Writer, Writer](cycle); err != nil {
+		t.Fatalf("Alias failed: %v", err)
+	}
+	if err := Alias[Writer, io.Writer](cycle); err != nil {
+		t.Fatalf("Alias failed: %v", err)
+	}
+	if _, err := GetScoped[io.Writer](cycle); !errors.Is(err, ErrCircularDependency) {
+		t.Errorf("Aliases which lead back to themselves should be circular: %v", err)
+	}
+}
+
+func TestHydrateTags(t *testing.T) {
+	type Secret string
+	type Port int
+	type Env struct {
+		Secret Secret `deps:"-"`
+		Port   Port   `deps:"optional"`
+	}
+	type Server struct {
+		Port Port
+	}
+
+	s := New()
+	secret := Secret("provided")
+	SetScoped(s, &secret)
+	s.Require(TypeOf[Port]())
+
+	env := Env{Secret: "original"}
+	if err := s.Hydrate(&env); err != nil {
+		t.Errorf("Optional fields of a required type should not fail hydration: %v", err)
+	}
+	if env.Secret != "original" {
+		t.Errorf("Skipped fields should not be hydrated: %v", env.Secret)
+	}
+	if err := s.Hydrate(&Server{}); !errors.Is(err, ErrNoProvider) || !strings.Contains(err.Error(), "Port") {
+		t.Errorf("Fields of a required type should fail hydration: %v", err)
+	}
+
+	ProvideScoped(s, Provider[Port]{
+		Create: func(scope *Scope) (*Port, error) {
+			return nil, fmt.Errorf("%w: port is not configured", ErrNoProvider)
+		},
+	})
+	if err := s.Hydrate(&env); !errors.Is(err, ErrNoProvider) {
+		t.Errorf("Optional fields should not hide errors creating their value: %v", err)
+	}
+}
+
+func TestGetAll(t *testing.T) {
+	type Database struct{ Ready bool }
+	type Cache struct{ Ready bool }
+	type Queue struct{ Ready bool }
+
+	s := New()
+	SetScoped(s, &Database{Ready: true})
+	SetScoped(s, &Queue{Ready: true})
+
+	values, err := s.GetAll(TypeOf[Database](), TypeOf[Cache](), TypeOf[Queue]())
+	if err == nil || !strings.Contains(err.Error(), "Cache") {
+		t.Errorf("GetAll should return the error for Cache: %v", err)
+	}
+	if len(values) != 3 || values[1] != nil {
+		t.Fatalf("GetAll should return a nil value for Cache: %v", values)
+	}
+	if !values[0].(*Database).Ready || !values[2].(*Queue).Ready {
+		t.Errorf("GetAll should return the resolved values in order: %v", values)
+	}
+}
+
+func TestConcurrentFree(t *testing.T) {
+	type Connection struct{ Closed bool }
+
+	freed := int32(0)
+
+	s := New()
+	ProvideScoped(s, Provider[Connection]{
+		Create: func(scope *Scope) (*Connection, error) {
+			return &Connection{}, nil
+		},
+		Free: func(scope *Scope, value *Connection) error {
+			atomic.AddInt32(&freed, 1)
+			return nil
+		},
+	})
+
+	for i := 0; i < 100; i++ {
+		GetScoped[Connection](s)
+
+		wg := sync.WaitGroup{}
+		for j := 0; j < 2; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.Free()
+			}()
+		}
+		wg.Wait()
+	}
+
+	if freed != 100 {
+		t.Errorf("Free was called %d times, expected once per value", freed)
+	}
+}
+
+func TestProvideValue(t *testing.T) {
+	type Database struct{ Closed bool }
+
+	db := &Database{}
+
+	s := New()
+	ProvideValue(s, db, func(value *Database) error {
+		value.Closed = true
+		return nil
+	})
+
+	if got, _ := GetScoped[Database](s); got != db {
+		t.Errorf("ProvideValue should set the given value")
+	}
+	if err := s.Free(); err != nil || !db.Closed {
+		t.Errorf("Free should close the value: %v", err)
+	}
+	if _, err := GetScoped[Database](s); err != ErrNoProvider {
+		t.Errorf("A freed value should not be created again: %v", err)
+	}
+}
+
+func TestHydrateMapMerge(t *testing.T) {
+	type Port int
+	type Service struct {
+		Name string
+		Port Port
+	}
+
+	port := Port(8080)
+	s := New()
+	s.Set(&port)
+
+	services := map[string]Service{
+		"api": {Name: "api"},
+	}
+	if err := s.Hydrate(&services); err != nil {
+		t.Fatalf("Hydrate failed: %v", err)
+	}
+	if services["api"].Name != "api" || services["api"].Port != 8080 {
+		t.Errorf("Hydrating a map should merge provided fields into existing values: %+v", services["api"])
+	}
+}
+
+func TestCreateError(t *testing.T) {
+	type Database struct{}
+
+	failure := errors.New("connection refused")
+
+	s := New().Spawn()
+	ProvideScoped(s, Provider[Database]{
+		Create: func(scope *Scope) (*Database, error) {
+			return nil, failure
+		},
+	})
+
+	_, err := GetScoped[Database](s)
+
+	var createErr *CreateError
+	if !errors.As(err, &createErr) {
+		t.Fatalf("Create errors should be wrapped in a CreateError: %v", err)
+	}
+	if createErr.Type != TypeOf[Database]() || createErr.Depth != 2 {
+		t.Errorf("CreateError should record the type and depth: %v %d", createErr.Type, createErr.Depth)
+	}
+	if !errors.Is(err, failure) {
+		t.Errorf("CreateError should unwrap to the original error: %v", err)
+	}
+}
+
+func TestSetGlobal(t *testing.T) {
+	type Port int
+
+	previous := Global()
+	restore := SetGlobal(nil)
+
+	port := Port(8080)
+	Set(&port)
+	if p, err := Get[Port](); err != nil || *p != 8080 {
+		t.Errorf("Set should use the replaced global scope: %v", err)
+	}
+	if New().parent != Global() {
+		t.Errorf("New should create children of the replaced global scope")
+	}
+
+	restore()
+
+	if Global() != previous {
+		t.Errorf("Restore should put back the previous global scope")
+	}
+	if _, err := Get[Port](); err != ErrNoProvider {
+		t.Errorf("Values set on the replaced global scope should not be visible: %v", err)
+	}
+}
+
+func TestInvokeErr(t *testing.T) {
+	type Session struct{}
+
+	freed := 0
+	failure := errors.New("unauthorized")
+
+	s := New()
+	ProvideScoped(s, Provider[Session]{
+		Lifetime: LifetimeOnce,
+		Create: func(scope *Scope) (*Session, error) {
+			return &Session{}, nil
+		},
+		Free: func(scope *Scope, value *Session) error {
+			freed++
+			return nil
+		},
+	})
+
+	err := s.InvokeErr(func(session *Session) error {
+		return failure
+	})
+	if err != failure {
+		t.Errorf("InvokeErr should return the error returned by the function: %v", err)
+	}
+	if freed != 1 {
+		t.Errorf("InvokeErr should free once values before returning")
+	}
+
+	err = s.InvokeErr(func(session *Session) (int, error) {
+		return 1, nil
+	})
+	if err != nil {
+		t.Errorf("InvokeErr should return nil when the function succeeds: %v", err)
+	}
+}
+
+func TestProvideInterface(t *testing.T) {
+	type Logger interface{ Handle() string }
+
+	console := &consoleLogger{}
+
+	s := New()
+	err := ProvideInterface[Logger](s, LifetimeScope, func(scope *Scope) (*consoleLogger, error) {
+		return console, nil
+	})
+	if err != nil {
+		t.Fatalf("ProvideInterface failed: %v", err)
+	}
+
+	logger, err := GetScoped[Logger](s)
+	if err != nil || (*logger).(*consoleLogger) != console {
+		t.Errorf("ProvideInterface should provide the implementation: %v", err)
+	}
+
+	err = ProvideInterface[Logger](s, LifetimeScope, func(scope *Scope) (int, error) {
+		return 0, nil
+	})
+	if !errors.Is(err, ErrNotImplemented) {
+		t.Errorf("ProvideInterface should reject types which don't implement the interface: %v", err)
+	}
+}
+
+func TestDynamicParent(t *testing.T) {
+	type Tenant struct{ Depth int }
+
+	parent := New()
+	parent.Dynamic = func(typ reflect.Type, scope *Scope) (any, error) {
+		if typ == TypeOf[Tenant]() {
+			return &Tenant{Depth: scope.depth()}, nil
+		}
+		return nil, nil
+	}
+	grandchild := parent.Spawn().Spawn()
+
+	tenant, err := GetScoped[Tenant](grandchild)
+	if err != nil || tenant == nil {
+		t.Fatalf("A parent Dynamic should handle types requested from a grandchild: %v", err)
+	}
+	if tenant.Depth != 3 {
+		t.Errorf("A parent Dynamic should be given the requesting scope, got depth %d", tenant.Depth)
+	}
+}
+
+func TestMustGet(t *testing.T) {
+	type Missing struct{}
+
+	defer func() {
+		message := fmt.Sprint(recover())
+		if !strings.Contains(message, "Missing") || !strings.Contains(message, ErrNoProvider.Error()) {
+			t.Errorf("MustGetScoped should panic with the type and error: %s", message)
+		}
+	}()
+
+	MustGetScoped[Missing](New())
+	t.Errorf("MustGetScoped should panic when there is no provider")
+}
+
+func TestMustInvoke(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("MustInvoke should panic when given a non-function")
+		}
+	}()
+
+	MustInvoke(42)
+}
+
+func TestSetAs(t *testing.T) {
+	s := New()
+
+	var handler Handler = consoleLogger{}
+	s.Set(handler)
+	if _, err := GetScoped[Handler](s); err != ErrNoProvider {
+		t.Errorf("Set should store an interface value under its concrete type: %v", err)
+	}
+	if _, err := GetScoped[consoleLogger](s); err != nil {
+		t.Errorf("Set should store an interface value under its concrete type: %v", err)
+	}
+
+	SetAs(s, handler)
+	if h, err := GetScoped[Handler](s); err != nil || (*h).Handle() != handler.Handle() {
+		t.Errorf("SetAs should store the value under the interface: %v", err)
+	}
+
+	SetAs(s, fileLogger{path: "log.txt"})
+	if l, err := GetScoped[fileLogger](s); err != nil || l.path != "log.txt" {
+		t.Errorf("SetAs should store a concrete value under its type: %v", err)
+	}
+
+	if err := s.Set(nil); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("Set should reject nil values: %v", err)
+	}
+}
+
+func TestHydrateNilSlices(t *testing.T) {
+	type Port int
+	type Env struct {
+		Ports []Port
+	}
+
+	port := Port(8080)
+	s := New()
+	s.Set(&port)
+
+	env := Env{}
+	s.Hydrate(&env)
+	if env.Ports != nil {
+		t.Errorf("Nil slices should be left as is by default: %v", env.Ports)
+	}
+
+	s.HydrateNilSlices = true
+	s.Hydrate(&env)
+	if len(env.Ports) != 1 || env.Ports[0] != 8080 {
+		t.Errorf("Nil slices should be hydrated with the provided element: %v", env.Ports)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	type Logger interface{ Handle() string }
+
+	s := New()
+	s.ResolveByInterface = true
+	ProvideScoped(s, Provider[consoleLogger]{
+		Create: func(scope *Scope) (*consoleLogger, error) {
+			return &consoleLogger{}, nil
+		},
+	})
+	ProvideScoped(s, Provider[fileLogger]{
+		Priority: 10,
+		Create: func(scope *Scope) (*fileLogger, error) {
+			return &fileLogger{path: "app.log"}, nil
+		},
+	})
+
+	logger, err := GetScoped[Logger](s)
+	if err != nil || (*logger).Handle() != (&fileLogger{path: "app.log"}).Handle() {
+		t.Errorf("The provider with the highest priority should be resolved: %v", err)
+	}
+
+	type Middleware struct{ Name string }
+	for _, name := range []string{"first", "second"} {
+		name := name
+		ProvideGroupScoped(s, Provider[Middleware]{
+			Create: func(scope *Scope) (*Middleware, error) {
+				return &Middleware{Name: name}, nil
+			},
+		})
+	}
+	ProvideGroupScoped(s, Provider[Middleware]{
+		Priority: 1,
+		Create: func(scope *Scope) (*Middleware, error) {
+			return &Middleware{Name: "preferred"}, nil
+		},
+	})
+
+	group, _ := GetGroupScoped[Middleware](s)
+	names := []string{}
+	for _, middleware := range group {
+		names = append(names, middleware.Name)
+	}
+	if strings.Join(names, ",") != "preferred,first,second" {
+		t.Errorf("Groups should be ordered by priority then registration: %v", names)
+	}
+}
+
+func TestHydrateChanged(t *testing.T) {
+	type Port int
+	type Config struct {
+		Port Port
+		Name string
+	}
+
+	port := Port(8080)
+	s := New()
+	s.Set(&port)
+
+	config := Config{Name: "api"}
+	changed, err := s.HydrateChanged(&config)
+	if err != nil || !changed || config.Port != 8080 {
+		t.Errorf("HydrateChanged should report setting a new value: %v", err)
+	}
+
+	changed, err = s.HydrateChanged(&config)
+	if err != nil || changed {
+		t.Errorf("HydrateChanged should report nothing changed when rehydrating: %v", err)
+	}
+}
+
+func TestFreeTagged(t *testing.T) {
+	type Transaction struct{}
+	type Connection struct{}
+
+	freed := []string{}
+
+	s := New()
+	ProvideScoped(s, Provider[Connection]{
+		Lifetime: LifetimeScope,
+		Tags:     []string{"connection"},
+		Create: func(scope *Scope) (*Connection, error) {
+			return &Connection{}, nil
+		},
+		Free: func(scope *Scope, value *Connection) error {
+			freed = append(freed, "connection")
+			return nil
+		},
+	})
+	ProvideScoped(s, Provider[Transaction]{
+		Lifetime: LifetimeScope,
+		Tags:     []string{"request"},
+		Create: func(scope *Scope) (*Transaction, error) {
+			return &Transaction{}, nil
+		},
+		Free: func(scope *Scope, value *Transaction) error {
+			freed = append(freed, "request")
+			return nil
+		},
+	})
+
+	GetScoped[Connection](s)
+	GetScoped[Transaction](s)
+
+	if err := s.FreeTagged("request"); err != nil {
+		t.Errorf("FreeTagged failed: %v", err)
+	}
+	if strings.Join(freed, ",") != "request" {
+		t.Errorf("FreeTagged should only free tagged values: %v", freed)
+	}
+	if _, exists := s.getInstance(binding{typ: TypeOf[Connection]()}); !exists {
+		t.Errorf("Values without the tag should remain")
+	}
+}
+
+func TestProvideAsync(t *testing.T) {
+	type Index struct{ Size int }
+
+	created := int32(0)
+	release := make(chan struct{})
+
+	s := New()
+	ProvideAsync(s, Provider[Index]{
+		Create: func(scope *Scope) (*Index, error) {
+			atomic.AddInt32(&created, 1)
+			<-release
+			return &Index{Size: 42}, nil
+		},
+	})
+
+	future, err := GetScoped[Future[Index]](s)
+	if err != nil {
+		t.Fatalf("Getting a future should not wait for the value: %v", err)
+	}
+
+	indexes := make([]*Index, 2)
+	wg := sync.WaitGroup{}
+	for i := range indexes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			f, _ := GetScoped[Future[Index]](s)
+			indexes[i], _ = f.Await()
+		}(i)
+	}
+	close(release)
+	wg.Wait()
+
+	index, err := future.Await()
+	if err != nil || index.Size != 42 || indexes[0] != index || indexes[1] != index {
+		t.Errorf("Awaits should share the created value: %v", err)
+	}
+	if created != 1 {
+		t.Errorf("Create was called %d times, expected once", created)
+	}
+
+	failure := errors.New("failed")
+	ProvideAsync(s, Provider[int]{
+		Create: func(scope *Scope) (*int, error) {
+			return nil, failure
+		},
+	})
+	failed, _ := GetScoped[Future[int]](s)
+	if _, err := failed.Await(); !errors.Is(err, failure) {
+		t.Errorf("Await should return the create error: %v", err)
+	}
+
+	type Report struct{ Attempts int }
+
+	attempts := int32(0)
+	used := int32(0)
+	r := New()
+	r.Use(func(next func() (any, error), key reflect.Type) (any, error) {
+		if key == TypeOf[Report]() {
+			atomic.AddInt32(&used, 1)
+		}
+		return next()
+	})
+	ProvideAsync(r, Provider[Report]{
+		Retries: 2,
+		Create: func(scope *Scope) (*Report, error) {
+			if atomic.AddInt32(&attempts, 1) < 3 {
+				return nil, failure
+			}
+			return &Report{}, nil
+		},
+	})
+	ProvideDecorator(r, func(scope *Scope, report *Report) (*Report, error) {
+		report.Attempts = int(atomic.LoadInt32(&attempts))
+		return report, nil
+	})
+	reports, _ := GetScoped[Future[Report]](r)
+	report, err := reports.Await()
+	if err != nil || report.Attempts != 3 {
+		t.Errorf("Async values should be retried and decorated: %v %v", report, err)
+	}
+	if used != 1 {
+		t.Errorf("Middleware should wrap async creation once, was called %d times", used)
+	}
+}
+
+func TestInvokeWith(t *testing.T) {
+	type Port int
+	type RequestID string
+
+	port := Port(8080)
+	s := New()
+	s.Set(&port)
+	id := RequestID("provided")
+	s.Set(&id)
+
+	var handler Handler = consoleLogger{}
+	result, err := s.InvokeWith(func(p Port, id RequestID, h Handler) string {
+		return fmt.Sprintf("%d %s %s", p, id, h.Handle())
+	}, RequestID("override"), handler)
+	if err != nil || result[0] != "8080 override console" {
+		t.Errorf("Overrides should replace the resolved arguments: %v %v", result, err)
+	}
+
+	_, err = s.InvokeWith(func(p Port) {}, "unmatched")
+	if !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("An override without a matching argument should fail: %v", err)
+	}
+}
+
+func TestHydrateInterfaceField(t *testing.T) {
+	type Service struct {
+		Handler Handler
+	}
+
+	s := New()
+	ProvideScoped(s, Provider[Handler]{
+		Create: func(scope *Scope) (*Handler, error) {
+			var handler Handler = consoleLogger{}
+			return &handler, nil
+		},
+	})
+
+	service := Service{}
+	if err := s.Hydrate(&service); err != nil || service.Handler == nil || service.Handler.Handle() != "console" {
+		t.Errorf("A nil interface field should be set by its provider: %v", err)
+	}
+
+	s.ResolveByInterface = true
+	type Other interface{ Handle() string }
+	type OtherService struct {
+		Other Other
+	}
+	other := OtherService{}
+	if err := s.Hydrate(&other); err != nil || other.Other == nil {
+		t.Errorf("A nil interface field should be set by an implementing provider: %v", err)
+	}
+}
+
+func TestProvideFactory(t *testing.T) {
+	type Shard int
+	type Connection struct{ Shard Shard }
+
+	created := int32(0)
+
+	s := New()
+	ProvideFactory(s, func(scope *Scope, shard Shard) (*Connection, error) {
+		atomic.AddInt32(&created, 1)
+		return &Connection{Shard: shard}, nil
+	})
+
+	first, err := GetFactory[Shard, Connection](s, 1)
+	if err != nil || first.Shard != 1 {
+		t.Fatalf("GetFactory should create the value for the key: %v", err)
+	}
+	second, _ := GetFactory[Shard, Connection](s, 2)
+	again, _ := GetFactory[Shard, Connection](s, 1)
+	if second.Shard != 2 || again != first || created != 2 {
+		t.Errorf("GetFactory should cache one value per key, created %d", created)
+	}
+
+	s.Free()
+	if freed, _ := GetFactory[Shard, Connection](s, 1); freed == first {
+		t.Errorf("Free should release the cached values")
+	}
+
+	if _, err := GetFactory[string, Connection](s, "missing"); err != ErrNoProvider {
+		t.Errorf("GetFactory without a factory should return ErrNoProvider: %v", err)
+	}
+}
+
+func TestLifetimeContext(t *testing.T) {
+	type Transaction struct{}
+
+	freed := make(chan struct{}, 2)
+
+	s := New()
+	ProvideScoped(s, Provider[Transaction]{
+		Lifetime: LifetimeContext,
+		Create: func(scope *Scope) (*Transaction, error) {
+			return &Transaction{}, nil
+		},
+		Free: func(scope *Scope, value *Transaction) error {
+			freed <- struct{}{}
+			return nil
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.InvokeContext(ctx, func(tx *Transaction) {})
+
+	select {
+	case <-freed:
+		t.Fatalf("The value should not be freed before the context is done")
+	default:
+	}
+
+	cancel()
+	select {
+	case <-freed:
+	case <-time.After(time.Second):
+		t.Fatalf("The value should be freed when the context is done")
+	}
+
+	s.Free()
+	if len(freed) != 0 {
+		t.Errorf("The value should only be freed once")
+	}
+}
+
+func BenchmarkInvokeStruct(b *testing.B) {
+	type Port int
+	type Host string
+	type Env struct {
+		Port    Port
+		Host    Host
+		Name    string `deps:"-"`
+		Timeout time.Duration
+		Retries int `deps:"optional"`
+		Tags    [4]string
+	}
+
+	port := Port(8080)
+	host := Host("localhost")
+	s := New()
+	s.Set(&port)
+	s.Set(&host)
+
+	fn := func(e Env) {}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Invoke(fn)
+	}
+}
+
+func TestGetOrSet(t *testing.T) {
+	type Config struct{ Name string }
+
+	s := New()
+	first, _ := GetOrSet(s, &Config{Name: "first"})
+	second, _ := GetOrSet(s, &Config{Name: "second"})
+	if first.Name != "first" || second != first {
+		t.Errorf("GetOrSet should return the first value set: %v %v", first, second)
+	}
+
+	type Database struct{ Host string }
+
+	failing := New()
+	ProvideScoped(failing, Provider[Database]{
+		Create: func(scope *Scope) (*Database, error) {
+			return nil, io.ErrClosedPipe
+		},
+	})
+	database, err := GetOrSet(failing, &Database{Host: "fallback"})
+	if !errors.Is(err, io.ErrClosedPipe) || database != nil {
+		t.Errorf("GetOrSet should return the error creating the value instead of the fallback: %v %v", database, err)
+	}
+
+	frozen := New()
+	frozen.Freeze()
+	if _, err := GetOrSet(frozen, &Config{}); !errors.Is(err, ErrScopeFrozen) {
+		t.Errorf("GetOrSet should not set a value on a frozen scope: %v", err)
+	}
+
+	type Counter struct{ Value int }
+	values := make([]*Counter, 50)
+	wg := sync.WaitGroup{}
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], _ = GetOrSet(s, &Counter{Value: i})
+		}(i)
+	}
+	wg.Wait()
+	for _, value := range values {
+		if value != values[0] {
+			t.Fatalf("Concurrent GetOrSet calls should all return the same value")
+		}
+	}
+}
+
+func TestProviderReset(t *testing.T) {
+	type Buffer struct{ Data []byte }
+
+	created := 0
+	freed := 0
+
+	s := New()
+	ProvideScoped(s, Provider[Buffer]{
+		Lifetime: LifetimeOnce,
+		Create: func(scope *Scope) (*Buffer, error) {
+			created++
+			return &Buffer{}, nil
+		},
+		Reset: func(scope *Scope, value *Buffer) error {
+			value.Data = value.Data[:0]
+			return nil
+		},
+		Free: func(scope *Scope, value *Buffer) error {
+			freed++
+			return nil
+		},
+	})
+
+	for i := 0; i < 2; i++ {
+		s.Invoke(func(b *Buffer) {
+			if len(b.Data) != 0 {
+				t.Errorf("The value should be reset between invokes")
+			}
+			b.Data = append(b.Data, 'x')
+		})
+	}
+
+	if created != 1 || freed != 0 {
+		t.Errorf("Reset values should be reused instead of freed, created %d freed %d", created, freed)
+	}
+
+	s.Free()
+	if freed != 1 {
+		t.Errorf("Free should still free reset values")
+	}
+}
+
+func TestSetNil(t *testing.T) {
+	type Config struct{}
+
+	var config *Config
+
+	s := New()
+	if err := SetChecked(s, config); !errors.Is(err, ErrNilValue) {
+		t.Errorf("SetChecked should reject a nil pointer: %v", err)
+	}
+	if err := s.Set(config); !errors.Is(err, ErrNilValue) {
+		t.Errorf("Set should reject a nil pointer: %v", err)
+	}
+	if _, err := GetScoped[Config](s); err != ErrNoProvider {
+		t.Errorf("A nil pointer should not be set: %v", err)
+	}
+}
+
+func TestScopeString(t *testing.T) {
+	type Cache struct{}
+	type Config struct{}
+	type Database struct{}
+
+	parent := New()
+	parent.Dynamic = func(typ reflect.Type, scope *Scope) (any, error) {
+		return nil, nil
+	}
+	ProvideScoped(parent, Provider[Database]{
+		Create: func(scope *Scope) (*Database, error) {
+			t.Errorf("String should not create values")
+			return &Database{}, nil
+		},
+	})
+
+	child := parent.Spawn()
+	SetScoped(child, &Config{})
+	ProvideScoped(child, Provider[Cache]{
+		Lifetime: LifetimeScope,
+		Create: func(scope *Scope) (*Cache, error) {
+			return &Cache{}, nil
+		},
+	})
+	GetScoped[Cache](child)
+
+	dump := child.String()
+	for _, expected := range []string{
+		"scope\n",
+		"  deps.Cache: scope provider, value\n",
+		"  deps.Config: value\n",
+		"  parent\n",
+		"    deps.Database: forever provider\n",
+		"    dynamic\n",
+	} {
+		if !strings.Contains(dump, expected) {
+			t.Errorf("Expected %q in:\n%s", expected, dump)
+		}
+	}
+}
+
+func TestHydrateMaxDepth(t *testing.T) {
+	type Node struct {
+		Next *Node
+	}
+
+	root := &Node{}
+	curr := root
+	for i := 0; i < 20; i++ {
+		curr.Next = &Node{}
+		curr = curr.Next
+	}
+
+	s := New()
+	if err := s.Hydrate(root); err != nil {
+		t.Errorf("Hydrate should allow the default depth: %v", err)
+	}
+
+	s.HydrateMaxDepth = 5
+	if err := s.Hydrate(root); !errors.Is(err, ErrHydrateDepthExceeded) {
+		t.Errorf("Hydrate should stop past the max depth: %v", err)
+	}
+}
+
+func TestProvideChecked(t *testing.T) {
+	type Config struct{}
+
+	s := New()
+	if err := ProvideChecked(s, Provider[Config]{}); !errors.Is(err, ErrMissingCreate) {
+		t.Errorf("ProvideChecked should reject a provider without Create: %v", err)
+	}
+	if _, err := GetScoped[Config](s); err != ErrNoProvider {
+		t.Errorf("A rejected provider should not be registered: %v", err)
+	}
+	if err := ProvideChecked(s, Provider[Config]{AllowMissingCreate: true}); err != nil {
+		t.Errorf("ProvideChecked should accept a missing Create when allowed: %v", err)
+	}
+}
+
+func TestInvokeScope(t *testing.T) {
+	s := New()
+
+	var given *Scope
+	s.Invoke(func(scope *Scope) {
+		given = scope
+	})
+	if given != s {
+		t.Errorf("Invoke should pass the invoking scope")
+	}
+
+	type Service struct{ Scope *Scope }
+	ProvideFunc(s, func(scope *Scope) (*Service, error) {
+		return &Service{Scope: scope}, nil
+	})
+	service, err := GetScoped[Service](s)
+	if err != nil || service.Scope == nil || service.Scope.state != s.state {
+		t.Errorf("Constructors should be given the resolving scope: %v", err)
+	}
+}
+
+func TestLifetimeTransient(t *testing.T) {
+	type Message struct{ Body string }
+
+	s := New()
+	ProvideScoped(s, Provider[Message]{
+		Lifetime: LifetimeTransient,
+		Create: func(scope *Scope) (*Message, error) {
+			return &Message{}, nil
+		},
+	})
+
+	first, _ := GetScoped[Message](s)
+	second, _ := GetScoped[Message](s)
+	if first == nil || first == second {
+		t.Errorf("Transient values should be created for every request")
+	}
+	if len(s.instanceKeys()) != 0 {
+		t.Errorf("Transient values should not be stored on the scope")
+	}
+
+	child := s.Spawn()
+	if third, _ := GetScoped[Message](child); third == nil || third == first {
+		t.Errorf("Transient values from parent providers should be created for every request")
+	}
+}
+
+func TestInvokeMap(t *testing.T) {
+	s := New()
+	results, err := s.InvokeMap(func() (int, string, int) {
+		return 1, "two", 3
+	})
+	if err != nil {
+		t.Fatalf("InvokeMap failed: %v", err)
+	}
+	if results[TypeOf[string]()] != "two" || results[TypeOf[int]()] != 3 {
+		t.Errorf("InvokeMap should map results by type keeping the last duplicate: %v", results)
+	}
+
+	if _, err := s.InvokeMap(42); err != ErrNotFunc {
+		t.Errorf("InvokeMap should return invoke errors: %v", err)
+	}
+}
+
+func TestConstruct(t *testing.T) {
+	type Config struct{ URL string }
+	type DB struct{ URL string }
+
+	s := New()
+	s.Set(&Config{URL: "postgres://"})
+
+	err := s.Construct(func(config *Config) (*DB, error) {
+		return &DB{URL: config.URL}, nil
+	})
+	if err != nil {
+		t.Fatalf("Construct failed: %v", err)
+	}
+	if db, err := GetScoped[DB](s); err != nil || db.URL != "postgres://" {
+		t.Errorf("Construct should set the result on the scope: %v", err)
+	}
+
+	type Cache struct{}
+	failure := errors.New("failed")
+	err = s.Construct(func() (*Cache, error) {
+		return &Cache{}, failure
+	})
+	if err != failure {
+		t.Errorf("Construct should return the constructor error: %v", err)
+	}
+	if _, err := GetScoped[Cache](s); err != ErrNoProvider {
+		t.Errorf("Construct should not set results when the constructor fails: %v", err)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	type Config struct{ Name string }
+	type Connection struct{}
+
+	freed := 0
+
+	s := New()
+	s.Set(&Config{Name: "kept"})
+	snapshot := s.Snapshot()
+
+	ProvideScoped(s, Provider[Connection]{
+		Lifetime: LifetimeScope,
+		Create: func(scope *Scope) (*Connection, error) {
+			return &Connection{}, nil
+		},
+		Free: func(scope *Scope, value *Connection) error {
+			freed++
+			return nil
+		},
+	})
+	GetScoped[Connection](s)
+
+	if err := s.Restore(snapshot); err != nil {
+		t.Errorf("Restore failed: %v", err)
+	}
+	if freed != 1 {
+		t.Errorf("Restore should free values created since the snapshot")
+	}
+	if _, err := GetScoped[Connection](s); err != ErrNoProvider {
+		t.Errorf("Restore should remove providers added since the snapshot: %v", err)
+	}
+	if config, err := GetScoped[Config](s); err != nil || config.Name != "kept" {
+		t.Errorf("Restore should keep values from before the snapshot: %v", err)
+	}
+}
+
+func TestHydrateEmbeddedPointer(t *testing.T) {
+	type Port int
+	type Base struct {
+		Port Port
+	}
+	type Empty struct {
+		Name string
+	}
+	type Config struct {
+		*Base
+		*Empty
+	}
+
+	port := Port(8080)
+	s := New()
+	s.Set(&port)
+
+	config := Config{}
+	if err := s.Hydrate(&config); err != nil {
+		t.Fatalf("Hydrate failed: %v", err)
+	}
+	if config.Base == nil || config.Port != 8080 {
+		t.Errorf("A nil embedded pointer with provided values should be allocated and hydrated")
+	}
+	if config.Empty != nil {
+		t.Errorf("A nil embedded pointer without provided values should be left nil")
+	}
+}
+
+func TestInstall(t *testing.T) {
+	type Config struct{ Name string }
+	type DB struct{ Name string }
+
+	configModule := func(scope *Scope) error {
+		SetScoped(scope, &Config{Name: "app"})
+		return nil
+	}
+	dbModule := func(scope *Scope) error {
+		return ProvideFunc(scope, func(config *Config) (*DB, error) {
+			return &DB{Name: config.Name}, nil
+		})
+	}
+
+	s := New()
+	if err := s.Install(configModule, dbModule); err != nil {
+		t.Fatalf("Install failed: %v", err)
+	}
+	if db, err := GetScoped[DB](s); err != nil || db.Name != "app" {
+		t.Errorf("Installed modules should provide their types: %v", err)
+	}
+
+	failure := errors.New("failed")
+	err := s.Install(func(scope *Scope) error { return failure }, configModule)
+	if err == nil || err.Error() != failure.Error() {
+		t.Errorf("Install should return module errors: %v", err)
+	}
+}
+
+func TestAddDynamic(t *testing.T) {
+	type Tenant struct{ Name string }
+
+	s := New()
+	s.Dynamic = func(typ reflect.Type, scope *Scope) (any, error) {
+		return nil, nil
+	}
+	s.AddDynamic(func(typ reflect.Type, scope *Scope) (any, error) {
+		if typ == TypeOf[Tenant]() {
+			return &Tenant{Name: "acme"}, nil
+		}
+		return nil, nil
+	})
+
+	tenant, err := GetScoped[Tenant](s)
+	if err != nil || tenant.Name != "acme" {
+		t.Errorf("The next dynamic provider should be tried when one returns nil: %v", err)
+	}
+	if _, err := GetScoped[int](s); err != ErrNoProvider {
+		t.Errorf("Types no dynamic provider handles should not be provided: %v", err)
+	}
+}
+
+func TestLocate(t *testing.T) {
+	type Pool struct{}
+	type Session struct{}
+
+	parent := New()
+	ProvideScoped(parent, Provider[Pool]{
+		Create: func(scope *Scope) (*Pool, error) {
+			return &Pool{}, nil
+		},
+	})
+	ProvideScoped(parent, Provider[Session]{
+		Lifetime: LifetimeScope,
+		Create: func(scope *Scope) (*Session, error) {
+			return &Session{}, nil
+		},
+	})
+	child := parent.Spawn()
+
+	if located, ok := child.Locate(TypeOf[Pool]()); !ok || located != parent {
+		t.Errorf("Locate should return the scope with the provider before the value is created")
+	}
+
+	GetScoped[Pool](child)
+	GetScoped[Session](child)
+
+	if located, ok := child.Locate(TypeOf[Pool]()); !ok || located != parent {
+		t.Errorf("A forever value should be located on the scope with its provider")
+	}
+	if located, ok := child.Locate(TypeOf[Session]()); !ok || located != child {
+		t.Errorf("A scope value should be located on the requesting scope")
+	}
+	if _, ok := child.Locate(TypeOf[int]()); ok {
+		t.Errorf("Locate should return false for types without values or providers")
+	}
+}
+
+func TestInvokeSafe(t *testing.T) {
+	type Session struct{}
+
+	freed := 0
+
+	s := New()
+	ProvideScoped(s, Provider[Session]{
+		Lifetime: LifetimeOnce,
+		Create: func(scope *Scope) (*Session, error) {
+			return &Session{}, nil
+		},
+		Free: func(scope *Scope, value *Session) error {
+			freed++
+			return nil
+		},
+	})
+
+	failure := errors.New("boom")
+	_, err := s.InvokeSafe(func(session *Session) {
+		panic(failure)
+	})
+
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) || len(panicErr.Stack) == 0 {
+		t.Fatalf("InvokeSafe should return the panic as a PanicError: %v", err)
+	}
+	if !errors.Is(err, failure) {
+		t.Errorf("PanicError should unwrap to a panicked error: %v", err)
+	}
+	if freed != 1 {
+		t.Errorf("Once values should be freed after a panic")
+	}
+}
+
+func TestDeprecatedProvider(t *testing.T) {
+	type LegacyClient struct{}
+
+	messages := []string{}
+
+	s := New()
+	s.OnEvent = func(event Event) {
+		if event.Kind == EventDeprecated {
+			messages = append(messages, event.Message)
+		}
+	}
+	ProvideScoped(s, Provider[LegacyClient]{
+		Lifetime:   LifetimeTransient,
+		Deprecated: "use Client instead",
+		Create: func(scope *Scope) (*LegacyClient, error) {
+			return &LegacyClient{}, nil
+		},
+	})
+
+	GetScoped[LegacyClient](s)
+	GetScoped[LegacyClient](s)
+
+	if len(messages) != 1 || messages[0] != "use Client instead" {
+		t.Errorf("A deprecated provider should send its message once per scope: %v", messages)
+	}
+}
+
+func TestTryGet(t *testing.T) {
+	type Config struct{}
+	type DB struct{}
+	type Cache struct{}
+
+	failure := errors.New("failed")
+
+	s := New()
+	s.Set(&Config{})
+	ProvideScoped(s, Provider[DB]{
+		Create: func(scope *Scope) (*DB, error) {
+			return nil, failure
+		},
+	})
+
+	if config, found, err := TryGet[Config](s); config == nil || !found || err != nil {
+		t.Errorf("TryGet should return found values: %v", err)
+	}
+	if db, found, err := TryGet[DB](s); db != nil || !found || !errors.Is(err, failure) {
+		t.Errorf("TryGet should return create errors as found: %v", err)
+	}
+	if cache, found, err := TryGet[Cache](s); cache != nil || found || err != nil {
+		t.Errorf("TryGet should return missing values as not found: %v", err)
+	}
+}
+
+func TestHydrateSliceOfPointers(t *testing.T) {
+	type Service struct{ Name string }
+
+	s := New()
+	s.Set(&Service{Name: "provided"})
+
+	existing := &Service{Name: "existing"}
+	services := []*Service{nil, existing}
+	if err := s.Hydrate(&services); err != nil {
+		t.Fatalf("Hydrate failed: %v", err)
+	}
+	if services[0] == nil || services[0].Name != "provided" {
+		t.Errorf("Nil pointer elements should be allocated and hydrated: %v", services[0])
+	}
+	if services[1] != existing || existing.Name != "provided" {
+		t.Errorf("Non-nil pointer elements should be followed and hydrated: %v", existing)
+	}
+
+	type Unprovided struct{}
+	unprovided := []*Unprovided{nil}
+	s.Hydrate(&unprovided)
+	if unprovided[0] != nil {
+		t.Errorf("Nil pointer elements of types which aren't provided should be left nil")
+	}
+}
+
+func TestKeyFunc(t *testing.T) {
+	type Port int
+
+	s := New()
+	port := 8080
+	SetScoped(s, &port)
+
+	_, err := GetScoped[Port](s)
+	if err != ErrNoProvider {
+		t.Fatalf("Types should be resolved by exact type by default: %v", err)
+	}
+
+	s.KeyFunc = func(typ reflect.Type) any {
+		if typ.Kind() == reflect.Int {
+			return reflect.TypeOf(0)
+		}
+		return typ
+	}
+	if !s.Spawn().has(binding{typ: reflect.TypeOf(Port(0))}) {
+		t.Errorf("Port should be available by its key")
+	}
+	p, err := GetScoped[Port](s)
+	if err != nil || *p != 8080 {
+		t.Fatalf("Port should be resolved by the value of its underlying type: %v", err)
+	}
+	*p = 9090
+	value, _ := GetScoped[int](s)
+	if *value != 9090 {
+		t.Errorf("Port and int should share a value, got %d", *value)
+	}
+
+	wide := int64(1)
+	SetScoped(s, &wide)
+	_, err = GetScoped[int32](s)
+	if err != ErrNoProvider {
+		t.Errorf("Types with different keys should not be resolved: %v", err)
+	}
+
+	type Other int
+	other := Other(3)
+	SetScoped(s, &other)
+	_, err = GetScoped[Port](s)
+	if !errors.Is(err, ErrAmbiguousProvider) {
+		t.Errorf("Several types with the same key should be ambiguous: %v", err)
+	}
+}
+
+type methodService struct {
+	prefix string
+}
+
+func (s *methodService) Handle(name *string) string {
+	return s.prefix + *name
+}
+
+func (s methodService) Prefix() string {
+	return s.prefix
+}
+
+func TestInvokeMethod(t *testing.T) {
+	s := New()
+	ProvideScoped(s, Provider[methodService]{
+		Create: func(scope *Scope) (*methodService, error) {
+			return &methodService{prefix: "hello "}, nil
+		},
+	})
+	name := "world"
+	SetScoped(s, &name)
+
+	result, err := s.InvokeMethod((*methodService)(nil), "Handle")
+	if err != nil || result[0] != "hello world" {
+		t.Errorf("Method should be invoked on the provided receiver: %v %v", result, err)
+	}
+
+	result, err = s.Invoke((*methodService).Handle)
+	if err != nil || result[0] != "hello world" {
+		t.Errorf("Method expression should be invoked on the provided receiver: %v %v", result, err)
+	}
+
+	_, err = s.InvokeMethod((*methodService)(nil), "Missing")
+	if !errors.Is(err, ErrNotFunc) {
+		t.Errorf("Missing method should return ErrNotFunc: %v", err)
+	}
+
+	_, err = New().InvokeMethod((*methodService)(nil), "Handle")
+	if !errors.Is(err, ErrNoProvider) {
+		t.Errorf("Missing receiver should return ErrNoProvider: %v", err)
+	}
+
+	result, err = s.InvokeMethod(methodService{}, "Prefix")
+	if err != nil || result[0] != "hello " {
+		t.Errorf("Method should be invoked on the provided value receiver: %v %v", result, err)
+	}
+
+	_, err = New().InvokeMethod(methodService{}, "Prefix")
+	if !errors.Is(err, ErrNoProvider) {
+		t.Errorf("Missing value receiver should return ErrNoProvider: %v", err)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	type Config struct {
+		Name string
+	}
+	type Counter struct {
+		Count int
+	}
+
+	s := New()
+	created := 0
+	ProvideScoped(s, Provider[Counter]{
+		Lifetime: LifetimeScope,
+		Create: func(scope *Scope) (*Counter, error) {
+			created++
+			return &Counter{Count: created}, nil
+		},
+	})
+	s.Freeze()
+
+	if !s.Frozen() || s.Spawn().Frozen() {
+		t.Errorf("Only the frozen scope should be frozen")
+	}
+
+	err := s.Set(&Config{})
+	if !errors.Is(err, ErrScopeFrozen) {
+		t.Errorf("Set should fail on a frozen scope: %v", err)
+	}
+	err = ProvideChecked(s, Provider[Config]{Create: func(scope *Scope) (*Config, error) { return &Config{}, nil }})
+	if !errors.Is(err, ErrScopeFrozen) {
+		t.Errorf("ProvideChecked should fail on a frozen scope: %v", err)
+	}
+
+	expectFrozenPanic := func(name string, register func()) {
+		t.Helper()
+		defer func() {
+			recovered := recover()
+			if err, ok := recovered.(error); !ok || !errors.Is(err, ErrScopeFrozen) {
+				t.Errorf("%s should panic with ErrScopeFrozen: %v", name, recovered)
+			}
+		}()
+		register()
+	}
+	expectFrozenPanic("SetScoped", func() {
+		SetScoped(s, &Config{})
+	})
+	expectFrozenPanic("ProvideScoped", func() {
+		ProvideScoped(s, Provider[Config]{})
+	})
+	expectFrozenPanic("Require", func() {
+		s.Require(TypeOf[Config]())
+	})
+	if err := s.SetDefault(Config{}); !errors.Is(err, ErrScopeFrozen) {
+		t.Errorf("SetDefault should fail on a frozen scope: %v", err)
+	}
+	if err := s.Restore(s.Snapshot()); !errors.Is(err, ErrScopeFrozen) {
+		t.Errorf("Restore should fail on a frozen scope: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		counter, err := GetScoped[Counter](s)
+		if err != nil || counter.Count != 1 {
+			t.Errorf("Values should still be created and cached on a frozen scope: %v %v", counter, err)
+		}
+	}
+
+	child := s.Spawn()
+	SetScoped(child, &Config{Name: "child"})
+	config, err := GetScoped[Config](child)
+	if err != nil || config.Name != "child" {
+		t.Errorf("Children of a frozen scope should allow registering: %v", err)
+	}
+}
+
+func TestProvideFrom(t *testing.T) {
+	type Host struct{ Name string }
+	type Port struct{ Number int }
+	type Address struct{ Value string }
+
+	s := New()
+	ProvideScoped(s, Provider[Host]{
+		Create: func(scope *Scope) (*Host, error) {
+			return &Host{Name: "localhost"}, nil
+		},
+	})
+	ProvideScoped(s, Provider[Port]{
+		Create: func(scope *Scope) (*Port, error) {
+			return &Port{Number: 8080}, nil
+		},
+	})
+
+	err := ProvideFrom[Address](s, func(host *Host, port *Port) (*Address, error) {
+		return &Address{Value: fmt.Sprintf("%s:%d", host.Name, port.Number)}, nil
+	})
+	if err != nil {
+		t.Fatalf("ProvideFrom failed: %v", err)
+	}
+	address, err := GetScoped[Address](s)
+	if err != nil || address.Value != "localhost:8080" {
+		t.Errorf("ProvideFrom should compute the value from its dependencies: %v %v", address, err)
+	}
+
+	err = ProvideFrom[Address](s, func(host *Host) *Host {
+		return host
+	})
+	if !errors.Is(err, ErrInvalidConstructor) {
+		t.Errorf("ProvideFrom should reject functions which don't return *V: %v", err)
+	}
+
+	missing := New()
+	called := false
+	ProvideFrom[Address](missing, func(host *Host, port *Port) *Address {
+		called = true
+		return &Address{}
+	})
+	_, err = GetScoped[Address](missing)
+	if !errors.Is(err, ErrNoProvider) || !strings.Contains(err.Error(), "Host") || called {
+		t.Errorf("ProvideFrom should not be called when dependencies are missing: %v", err)
+	}
+}
+
+func TestInvokeValueSemantics(t *testing.T) {
+	type Config struct {
+		Name string
+		Tags []string
+	}
+	type Holder struct {
+		Config Config
+	}
+
+	scopes := map[string]func() *Scope{
+		"provided": func() *Scope {
+			s := New()
+			ProvideScoped(s, Provider[Config]{
+				Create: func(scope *Scope) (*Config, error) {
+					return &Config{Name: "original", Tags: []string{"a"}}, nil
+				},
+			})
+			return s
+		},
+		"set value": func() *Scope {
+			s := New()
+			s.Set(Config{Name: "original", Tags: []string{"a"}})
+			return s
+		},
+		"set pointer": func() *Scope {
+			s := New()
+			SetScoped(s, &Config{Name: "original", Tags: []string{"a"}})
+			return s
+		},
+	}
+
+	for name, create := range scopes {
+		t.Run(name, func(t *testing.T) {
+			s := create()
+			stored, _ := GetScoped[Config](s)
+
+			s.Invoke(func(c Config) {
+				c.Name = "value"
+			})
+			if stored.Name != "original" {
+				t.Errorf("Value argument should be a copy, got %s", stored.Name)
+			}
+
+			s.Invoke(func(h Holder) {
+				h.Config.Name = "field"
+			})
+			if stored.Name != "original" {
+				t.Errorf("Value field should be a copy, got %s", stored.Name)
+			}
+
+			s.Invoke(func(c *Config) {
+				c.Name = "pointer"
+			})
+			if stored.Name != "pointer" {
+				t.Errorf("Pointer argument should be the stored value, got %s", stored.Name)
+			}
+
+			s.Invoke(func(c Config) {
+				c.Tags[0] = "shared"
+			})
+			if stored.Tags[0] != "shared" {
+				t.Errorf("Value argument should share the memory its slices reference, got %s", stored.Tags[0])
+			}
+		})
+	}
+}
+
+func TestInvokeNamed(t *testing.T) {
+	type DB struct {
+		Host string
+	}
+
+	s := New()
+	ProvideNamed(s, "primary", Provider[DB]{
+		Create: func(scope *Scope) (*DB, error) {
+			return &DB{Host: "primary.local"}, nil
+		},
+	})
+	ProvideNamed(s, "replica", Provider[DB]{
+		Create: func(scope *Scope) (*DB, error) {
+			return &DB{Host: "replica.local"}, nil
+		},
+	})
+
+	result, err := s.InvokeNamed(func(primary *DB, replica DB) string {
+		return primary.Host + "," + replica.Host
+	}, map[int]string{0: "primary", 1: "replica"})
+	if err != nil || result[0] != "primary.local,replica.local" {
+		t.Errorf("Arguments should be resolved by their names: %v %v", result, err)
+	}
+
+	called := false
+	_, err = s.InvokeNamed(func(db *DB) {
+		called = true
+	}, map[int]string{0: "missing"})
+	if !errors.Is(err, ErrNoProvider) || called {
+		t.Errorf("Missing named argument should return ErrNoProvider: %v", err)
+	}
+
+	_, err = s.InvokeNamed(func(db *DB) {}, map[int]string{1: "primary"})
+	if !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("Name for an index which isn't an argument should return ErrInvalidValue: %v", err)
+	}
+}
+
+func TestFreeOnGC(t *testing.T) {
+	type Resource struct {
+		ID int
+	}
+
+	freed := atomic.Int32{}
+	func() {
+		s := New()
+		ProvideScoped(s, Provider[Resource]{
+			Lifetime: LifetimeScope,
+			Create: func(scope *Scope) (*Resource, error) {
+				return &Resource{ID: 1}, nil
+			},
+			Free: func(scope *Scope, value *Resource) error {
+				freed.Add(1)
+				return nil
+			},
+		})
+		GetScoped[Resource](s)
+		s.FreeOnGC()
+	}()
+
+	for i := 0; i < 50 && freed.Load() == 0; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if freed.Load() != 1 {
+		t.Errorf("Values should be freed when the scope is collected, freed %d times", freed.Load())
+	}
+
+	manual := atomic.Int32{}
+	func() {
+		s := New().Spawn()
+		ProvideScoped(s, Provider[Resource]{
+			Lifetime: LifetimeScope,
+			Create: func(scope *Scope) (*Resource, error) {
+				return &Resource{ID: 2}, nil
+			},
+			Free: func(scope *Scope, value *Resource) error {
+				manual.Add(1)
+				return nil
+			},
+		})
+		GetScoped[Resource](s)
+		s.FreeOnGC()
+		s.Free()
+	}()
+
+	for i := 0; i < 10; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if manual.Load() != 1 {
+		t.Errorf("Values freed manually should not be freed again, freed %d times", manual.Load())
+	}
+}
+
+func TestFreeOnGCWithScopeValue(t *testing.T) {
+	type Service struct {
+		Scope *Scope
+	}
+
+	freed := atomic.Int32{}
+	func() {
+		s := New()
+		ProvideScoped(s, Provider[Service]{
+			Lifetime: LifetimeScope,
+			Create: func(scope *Scope) (*Service, error) {
+				return &Service{Scope: scope}, nil
+			},
+			Free: func(scope *Scope, value *Service) error {
+				freed.Add(1)
+				return nil
+			},
+		})
+		GetScoped[Service](s)
+		s.FreeOnGC()
+	}()
+
+	for i := 0; i < 50 && freed.Load() == 0; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if freed.Load() != 1 {
+		t.Errorf("Values which keep the scope given to their provider should not keep the scope alive, freed %d times", freed.Load())
+	}
+}
+
+func TestFreeReplaced(t *testing.T) {
+	type Client struct {
+		Version int
+	}
+
+	freed := []int{}
+	provider := func(version int) Provider[Client] {
+		return Provider[Client]{
+			Lifetime: LifetimeScope,
+			Create: func(scope *Scope) (*Client, error) {
+				return &Client{Version: version}, nil
+			},
+			Free: func(scope *Scope, value *Client) error {
+				freed = append(freed, value.Version)
+				return nil
+			},
+		}
+	}
+
+	additive := New()
+	ProvideScoped(additive, provider(1))
+	GetScoped[Client](additive)
+	ProvideScoped(additive, provider(2))
+	client, _ := GetScoped[Client](additive)
+	if client.Version != 1 || len(freed) != 0 {
+		t.Errorf("Replaced values should stay by default: %v %v", client.Version, freed)
+	}
+
+	s := New()
+	s.FreeReplaced = true
+	ProvideScoped(s, provider(1))
+	GetScoped[Client](s)
+	ProvideScoped(s, provider(2))
+	if fmt.Sprint(freed) != "[1]" {
+		t.Errorf("Replaced value should be freed by its provider: %v", freed)
+	}
+	client, _ = GetScoped[Client](s)
+	if client.Version != 2 {
+		t.Errorf("New provider should create the value, got version %d", client.Version)
+	}
+
+	failing := provider(3)
+	failing.Free = func(scope *Scope, value *Client) error {
+		return io.ErrClosedPipe
+	}
+	ProvideScoped(s, failing)
+	GetScoped[Client](s)
+	err := ProvideChecked(s, provider(4))
+	if !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("ProvideChecked should return the error freeing the replaced value: %v", err)
+	}
+
+	ProvideScoped(s, failing)
+	GetScoped[Client](s)
+	ProvideScoped(s, provider(5))
+	client, _ = GetScoped[Client](s)
+	if client.Version != 5 {
+		t.Errorf("ProvideScoped should register the provider when freeing the replaced value fails: %d", client.Version)
+	}
+
+	freed = nil
+	set := New()
+	set.FreeReplaced = true
+	SetScoped(set, &Client{Version: 6})
+	ProvideScoped(set, provider(7))
+	client, _ = GetScoped[Client](set)
+	if len(freed) != 0 || client.Version != 7 {
+		t.Errorf("Replaced values which were set should be removed without being freed: %v %d", freed, client.Version)
+	}
+}
+
+func TestHydrateAll(t *testing.T) {
+	type Request struct {
+		ID int
+	}
+	type Handler struct {
+		Request Request
+	}
+
+	s := New()
+	created := 0
+	freed := 0
+	ProvideScoped(s, Provider[Request]{
+		Lifetime: LifetimeOnce,
+		Create: func(scope *Scope) (*Request, error) {
+			created++
+			return &Request{ID: created}, nil
+		},
+		Free: func(scope *Scope, value *Request) error {
+			freed++
+			return nil
+		},
+	})
+
+	handlers := []Handler{{}, {}, {}}
+	err := s.HydrateAll(&handlers[0], &handlers[1], &handlers[2])
+	if err != nil {
+		t.Fatalf("HydrateAll failed: %v", err)
+	}
+	for i, handler := range handlers {
+		if handler.Request.ID != 1 {
+			t.Errorf("Handler %d should share the once value", i)
+		}
+	}
+	if created != 1 || freed != 1 {
+		t.Errorf("Once value should be created and freed once, created %d freed %d", created, freed)
+	}
+
+	var handler Handler
+	err = s.HydrateAll(&handler, Handler{}, &handler, 4)
+	multi, ok := err.(multiError)
+	if !ok || len(multi.errors) != 2 || !errors.Is(multi.errors[0], ErrNotPointer) || !strings.Contains(multi.errors[1].Error(), "value 3") {
+		t.Errorf("Each value which is not a pointer should be reported: %v", err)
+	}
+	if !errors.Is(err, ErrNotPointer) {
+		t.Errorf("HydrateAll should return an error wrapping ErrNotPointer: %v", err)
+	}
+	if handler.Request.ID != 0 {
+		t.Errorf("Nothing should be hydrated when a value is not a pointer")
+	}
+}
+
+func TestPeek(t *testing.T) {
+	type Pool struct {
+		Size int
+	}
+
+	s := New()
+	created := 0
+	ProvideScoped(s, Provider[Pool]{
+		Create: func(scope *Scope) (*Pool, error) {
+			created++
+			return &Pool{Size: 10}, nil
+		},
+	})
+	child := s.Spawn()
+
+	if _, ok := Peek[Pool](child); ok || created != 0 {
+		t.Errorf("Peek should not find or create a value before it's created")
+	}
+
+	pool, _ := GetScoped[Pool](s)
+	peeked, ok := Peek[Pool](child)
+	if !ok || peeked != pool || created != 1 {
+		t.Errorf("Peek should return the value created on a parent: %v", peeked)
+	}
+
+	if _, ok := child.Peek(TypeOf[int]()); ok {
+		t.Errorf("Peek should return false for types without a value")
+	}
+}
+
+func TestProvideInit(t *testing.T) {
+	type Server struct {
+		Signals Init `deps:"signals"`
+		Port    int
+	}
+
+	s := New()
+	runs := atomic.Int32{}
+	ProvideInit(s, "signals", func(scope *Scope) error {
+		runs.Add(1)
+		return nil
+	})
+
+	child := s.Spawn()
+	for i := 0; i < 3; i++ {
+		var server Server
+		if err := child.Hydrate(&server); err != nil {
+			t.Fatalf("Hydrate failed: %v", err)
+		}
+		if runs.Load() != 1 {
+			t.Fatalf("Hydrating a tagged Init field should run the init")
+		}
+		if err := Initialize(child, "signals"); err != nil {
+			t.Fatalf("Initialize failed: %v", err)
+		}
+	}
+	if runs.Load() != 1 {
+		t.Errorf("Init should run once, ran %d times", runs.Load())
+	}
+
+	err := Initialize(s, "missing")
+	if !errors.Is(err, ErrNoProvider) {
+		t.Errorf("Initialize without an init should return ErrNoProvider: %v", err)
+	}
+
+	attempts := 0
+	ProvideInit(s, "flaky", func(scope *Scope) error {
+		attempts++
+		if attempts == 1 {
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	})
+	if err := Initialize(s, "flaky"); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Init error should be returned: %v", err)
+	}
+	if err := Initialize(s, "flaky", "flaky"); err != nil || attempts != 2 {
+		t.Errorf("Failed init should run again until it succeeds: %v %d", err, attempts)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	defer SetGlobal(nil)()
+
+	type Config struct{ Host string }
+	type Cache struct{ Size int }
+	type DB struct{ Host string }
+	type Service struct{ DB *DB }
+
+	s := New()
+	s.Set(&Config{Host: "localhost"})
+	ProvideFunc(s, func(cfg *Config, cache *Cache) *DB {
+		return &DB{Host: cfg.Host}
+	})
+	ProvideFunc(s, func(db *DB, scope *Scope) *Service {
+		return &Service{DB: db}
+	})
+	ProvideScoped(s, Provider[Cache]{})
+
+	dependencies, ok := s.Dependencies(TypeOf[DB]())
+	if !ok || len(dependencies) != 2 || dependencies[0] != TypeOf[*Config]() || dependencies[1] != TypeOf[*Cache]() {
+		t.Errorf("Dependencies should be the constructor arguments: %v", dependencies)
+	}
+	if _, ok := s.Dependencies(TypeOf[Cache]()); ok {
+		t.Errorf("Dependencies of a Create function should be unknown")
+	}
+	if err := s.Validate(); err != nil {
+		t.Errorf("Validate should pass when all dependencies are provided: %v", err)
+	}
+
+	missing := New()
+	ProvideFunc(missing, func(cfg *Config, cache *Cache) *DB {
+		return &DB{}
+	})
+	err := missing.Validate()
+	multi, ok := err.(multiError)
+	if !ok || len(multi.errors) != 2 || !errors.Is(multi.errors[0], ErrNoProvider) || !strings.Contains(err.Error(), "*deps.Config") {
+		t.Errorf("Validate should report each missing dependency: %v", err)
+	}
+	if !errors.Is(err, ErrNoProvider) {
+		t.Errorf("Validate should return an error wrapping ErrNoProvider: %v", err)
+	}
+	if _, created := Peek[DB](missing); created {
+		t.Errorf("Validate should not create values")
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	defer SetGlobal(nil)()
+
+	type Port struct {
+		Number int
+	}
+	ProvideScoped(Global(), Provider[Port]{
+		Create: func(scope *Scope) (*Port, error) {
+			return &Port{Number: 80}, nil
+		},
+	})
+
+	lines := []string{}
+	s := New()
+	s.SetLogger(func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	})
+	child := s.Spawn()
+
+	port, err := GetScoped[Port](child)
+	if err != nil || port.Number != 80 {
+		t.Fatalf("Port should be resolved from the global scope: %v", err)
+	}
+	expected := []string{
+		"resolving deps.Port at depth 2: miss local, checking parent",
+		"resolving deps.Port at depth 1: miss local, checking parent",
+	}
+	if fmt.Sprint(lines) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected log lines: %q", lines)
+	}
+
+	global := []string{}
+	Global().SetLogger(func(format string, args ...any) {
+		global = append(global, fmt.Sprintf(format, args...))
+	})
+	GetScoped[Port](child)
+	if len(global) != 1 || global[0] != "resolving deps.Port at depth 0: using value" {
+		t.Errorf("Global scope should log with its own logger: %q", global)
+	}
+
+	lines = lines[:0]
+	New().Spawn().SetLogger(nil)
+	GetScoped[Port](New())
+	if len(lines) != 0 {
+		t.Errorf("Scopes without a logger should not log: %q", lines)
+	}
+}
+
+func TestFuncValues(t *testing.T) {
+	type Formatter struct {
+		Format func(int) string
+	}
+
+	s := New()
+	err := s.Set(func(i int) string {
+		return fmt.Sprintf("#%d", i)
+	})
+	if err != nil {
+		t.Fatalf("Set should accept a function: %v", err)
+	}
+
+	result, err := s.Invoke(func(format func(int) string) string {
+		if format == nil {
+			return "nil"
+		}
+		return format(7)
+	})
+	if err != nil || result[0] != "#7" {
+		t.Errorf("Function argument should be injected: %v %v", result, err)
+	}
+
+	var formatter Formatter
+	if err := s.Hydrate(&formatter); err != nil || formatter.Format == nil || formatter.Format(8) != "#8" {
+		t.Errorf("Function field should be hydrated: %v", err)
+	}
+
+	format, err := GetScoped[func(int) string](s)
+	if err != nil || (*format)(9) != "#9" {
+		t.Errorf("Function should be returned by its type: %v", err)
+	}
+
+	var missing func(int) string
+	if err := New().Set(missing); !errors.Is(err, ErrNilValue) {
+		t.Errorf("Setting a nil function should return ErrNilValue: %v", err)
+	}
+	if IsNil([1]int{}) {
+		t.Errorf("Arrays are never nil")
+	}
+}
+
+func TestProviderRetries(t *testing.T) {
+	type Conn struct {
+		Attempt int
+	}
+
+	attempts := 0
+	s := New()
+	ProvideScoped(s, Provider[Conn]{
+		Retries:    2,
+		RetryDelay: time.Millisecond,
+		Create: func(scope *Scope) (*Conn, error) {
+			attempts++
+			if attempts < 3 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return &Conn{Attempt: attempts}, nil
+		},
+	})
+	conn, err := GetScoped[Conn](s)
+	if err != nil || conn.Attempt != 3 {
+		t.Errorf("Create should be retried until it succeeds: %v %v", conn, err)
+	}
+
+	failing := 0
+	ProvideScoped(s, Provider[int]{
+		Retries: 1,
+		Create: func(scope *Scope) (*int, error) {
+			failing++
+			return nil, io.ErrUnexpectedEOF
+		},
+	})
+	_, err = GetScoped[int](s)
+	if !errors.Is(err, io.ErrUnexpectedEOF) || !strings.Contains(err.Error(), "after 2 attempts") || failing != 2 {
+		t.Errorf("Last error should be returned with the number of attempts: %v %d", err, failing)
+	}
+
+	once := 0
+	ProvideScoped(s, Provider[string]{
+		Create: func(scope *Scope) (*string, error) {
+			once++
+			return nil, io.ErrUnexpectedEOF
+		},
+	})
+	_, err = GetScoped[string](s)
+	if err == nil || strings.Contains(err.Error(), "attempts") || once != 1 {
+		t.Errorf("Create should not be retried by default: %v %d", err, once)
+	}
+
+	type Cache struct{}
+
+	cached := 0
+	graphed := New()
+	ProvideScoped(graphed, Provider[Cache]{
+		Retries:    3,
+		RetryDelay: time.Hour,
+		Create: func(scope *Scope) (*Cache, error) {
+			cached++
+			return nil, io.ErrUnexpectedEOF
+		},
+	})
+	graphed.Graph()
+	if cached != 1 {
+		t.Errorf("Graph should not retry Create: %d", cached)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cached = 0
+	_, err = graphed.InvokeContext(ctx, func(cache *Cache) {})
+	if !errors.Is(err, io.ErrUnexpectedEOF) || cached != 1 {
+		t.Errorf("Create should not be retried once the context is done: %v %d", err, cached)
+	}
+}
+
+func TestSetParent(t *testing.T) {
+	type Config struct {
+		Name string
+	}
+
+	root := New()
+	child := root.Spawn()
+	grandchild := child.Spawn()
+
+	if err := root.SetParent(grandchild); !errors.Is(err, ErrParentCycle) {
+		t.Errorf("Setting a descendant as the parent should fail: %v", err)
+	}
+	if err := child.SetParent(child); !errors.Is(err, ErrParentCycle) {
+		t.Errorf("Setting the scope as its own parent should fail: %v", err)
+	}
+
+	other := New()
+	SetScoped(other, &Config{Name: "other"})
+	if err := grandchild.SetParent(other); err != nil {
+		t.Fatalf("SetParent failed: %v", err)
+	}
+	config, err := GetScoped[Config](grandchild)
+	if err != nil || config.Name != "other" {
+		t.Errorf("Values should be resolved from the new parent: %v", err)
+	}
+	if _, tracked := child.children[weak.Make(grandchild)]; tracked {
+		t.Errorf("Previous parent should stop tracking the scope")
+	}
+	if _, tracked := other.children[weak.Make(grandchild)]; !tracked {
+		t.Errorf("New parent should track the spawned scope")
+	}
+	if _, err := GetScoped[Config](root); err != ErrNoProvider {
+		t.Errorf("Failed SetParent should not change the parent: %v", err)
+	}
+}
+
+func TestHydrateVisit(t *testing.T) {
+	type Logger struct {
+		Level int
+	}
+	type Replica struct {
+		Logger Logger
+	}
+	type Config struct {
+		Name     string
+		Logger   Logger
+		Replicas []Replica
+		Primary  Logger `deps:"primary"`
+	}
+
+	s := New()
+	s.Set(Logger{Level: 2})
+
+	visited := []string{}
+	config := Config{Replicas: make([]Replica, 1)}
+	err := s.HydrateVisit(&config, func(path string, typ reflect.Type, set bool) {
+		visited = append(visited, fmt.Sprintf("%s %s %v", path, typ, set))
+	})
+	if err != nil {
+		t.Fatalf("HydrateVisit failed: %v", err)
+	}
+	expected := []string{
+		"Name string false",
+		"Logger deps.Logger true",
+		"Replicas []deps.Replica false",
+		"Replicas[0] deps.Replica false",
+		"Replicas[0].Logger deps.Logger true",
+		"Primary deps.Logger false",
+	}
+	if strings.Join(visited, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected visits:\n%s", strings.Join(visited, "\n"))
+	}
+	if config.Replicas[0].Logger.Level != 2 {
+		t.Errorf("HydrateVisit should hydrate like Hydrate")
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	type Settings struct {
+		Level string
+	}
+
+	env := "info"
+	freed := []string{}
+	s := New()
+	ProvideScoped(s, Provider[Settings]{
+		Create: func(scope *Scope) (*Settings, error) {
+			return &Settings{Level: env}, nil
+		},
+		Refresh: func(scope *Scope, current *Settings) (*Settings, bool, error) {
+			if current.Level == env {
+				return current, false, nil
+			}
+			return &Settings{Level: env}, true, nil
+		},
+		Free: func(scope *Scope, value *Settings) error {
+			freed = append(freed, value.Level)
+			return nil
+		},
+	})
+
+	if refreshed, err := s.Refresh(TypeOf[Settings]()); refreshed || err != nil {
+		t.Errorf("Refresh should do nothing before the value is created: %v %v", refreshed, err)
+	}
+
+	settings, _ := GetScoped[Settings](s)
+	if refreshed, err := s.Spawn().Refresh(TypeOf[Settings]()); refreshed || err != nil {
+		t.Errorf("Refresh should not replace an unchanged value: %v %v", refreshed, err)
+	}
+
+	env = "debug"
+	refreshed, err := s.Spawn().Refresh(TypeOf[Settings]())
+	if !refreshed || err != nil {
+		t.Errorf("Refresh should replace a changed value: %v %v", refreshed, err)
+	}
+	current, _ := GetScoped[Settings](s)
+	if current.Level != "debug" || current == settings {
+		t.Errorf("Get should return the refreshed value: %v", current.Level)
+	}
+	if fmt.Sprint(freed) != "[info]" {
+		t.Errorf("Replaced value should be freed: %v", freed)
+	}
+
+	s.Free()
+	if fmt.Sprint(freed) != "[info debug]" {
+		t.Errorf("Refreshed value should be freed by its provider: %v", freed)
+	}
+}
+
+func TestRejectNilPointers(t *testing.T) {
+	type DB struct {
+		Host string
+	}
+	type Options struct {
+		Debug bool
+	}
+
+	s := New()
+	called := false
+	_, err := s.Invoke(func(db *DB) {
+		called = true
+	})
+	if err != nil || !called {
+		t.Errorf("Nil pointers should be passed by default: %v", err)
+	}
+
+	s.RejectNilPointers = true
+	called = false
+	_, err = s.Invoke(func(db *DB, options Options) {
+		called = true
+	})
+	if !errors.Is(err, ErrNoProvider) || !strings.Contains(err.Error(), "*deps.DB") || called {
+		t.Errorf("Nil pointer argument should return ErrNoProvider: %v", err)
+	}
+
+	_, err = s.InvokeWith(func(db *DB) {}, (*DB)(nil))
+	if err != nil {
+		t.Errorf("Overridden nil pointers should be allowed: %v", err)
+	}
+
+	SetScoped(s, &DB{Host: "localhost"})
+	_, err = s.Invoke(func(db *DB, options Options) {
+		called = true
+	})
+	if err != nil || !called {
+		t.Errorf("Provided pointers should be passed: %v", err)
+	}
+}
+
+func TestResultErrs(t *testing.T) {
+	s := New()
+	r, _ := s.Invoke(func() (error, int, error, error) {
+		return io.EOF, 1, nil, io.ErrUnexpectedEOF
+	})
+
+	errs := r.Errs()
+	if len(errs) != 2 || errs[0] != io.EOF || errs[1] != io.ErrUnexpectedEOF {
+		t.Errorf("Errs should return every non-nil error: %v", errs)
+	}
+	combined := r.CombinedErr()
+	if combined == nil || !strings.Contains(combined.Error(), io.EOF.Error()) || !strings.Contains(combined.Error(), io.ErrUnexpectedEOF.Error()) {
+		t.Errorf("CombinedErr should report every error: %v", combined)
+	}
+	if !errors.Is(combined, io.EOF) || !errors.Is(combined, io.ErrUnexpectedEOF) {
+		t.Errorf("CombinedErr should wrap every error: %v", combined)
+	}
+
+	r, _ = s.Invoke(func() (int, error) {
+		return 1, nil
+	})
+	if len(r.Errs()) != 0 || r.CombinedErr() != nil {
+		t.Errorf("Results without errors should have no errors")
+	}
+}
+
+func TestSpawnInheriting(t *testing.T) {
+	type Session struct {
+		ID int
+	}
+
+	created := 0
+	freed := 0
+	s := New()
+	ProvideScoped(s, Provider[Session]{
+		Lifetime: LifetimeScope,
+		Create: func(scope *Scope) (*Session, error) {
+			created++
+			return &Session{ID: created}, nil
+		},
+		Free: func(scope *Scope, value *Session) error {
+			freed++
+			return nil
+		},
+	})
+	parent, _ := GetScoped[Session](s)
+
+	child := s.SpawnInheriting()
+	inherited, err := GetScoped[Session](child)
+	if err != nil || inherited != parent || created != 1 {
+		t.Errorf("Inheriting child should use the parent's value without creating it: %v %d", err, created)
+	}
+
+	spawned, _ := GetScoped[Session](s.Spawn())
+	if spawned == parent || created != 2 {
+		t.Errorf("Spawned child should create its own scoped value")
+	}
+
+	child.Free()
+	if freed != 0 {
+		t.Errorf("Inherited values should not be freed by the child")
+	}
+
+	own := &Session{ID: 100}
+	SetScoped(child, own)
+	if value, _ := GetScoped[Session](child); value != own {
+		t.Errorf("Child should use its own value once it sets one")
+	}
+	if value, _ := GetScoped[Session](s); value != parent {
+		t.Errorf("Child's value should not change the parent's")
+	}
+}
+
+func TestLifetimeViolation(t *testing.T) {
+	type Request struct {
+		ID int
+	}
+	type Cache struct {
+		Request *Request
+	}
+	type Handler struct {
+		Request *Request
+	}
+
+	s := New()
+	ProvideScoped(s, Provider[Request]{
+		Lifetime: LifetimeScope,
+		Create: func(scope *Scope) (*Request, error) {
+			return &Request{ID: 1}, nil
+		},
+	})
+	ProvideScoped(s, Provider[Cache]{
+		Lifetime: LifetimeForever,
+		Create: func(scope *Scope) (*Cache, error) {
+			request, err := GetScoped[Request](scope)
+			if err != nil {
+				return nil, err
+			}
+			return &Cache{Request: request}, nil
+		},
+	})
+	ProvideScoped(s, Provider[Handler]{
+		Lifetime: LifetimeOnce,
+		Create: func(scope *Scope) (*Handler, error) {
+			request, err := GetScoped[Request](scope)
+			if err != nil {
+				return nil, err
+			}
+			return &Handler{Request: request}, nil
+		},
+	})
+
+	_, err := GetScoped[Cache](s)
+	if !errors.Is(err, ErrLifetimeViolation) || !strings.Contains(err.Error(), "Cache") || !strings.Contains(err.Error(), "Request") {
+		t.Errorf("Forever value depending on a scope value should fail: %v", err)
+	}
+
+	GetScoped[Request](s)
+	_, err = GetScoped[Cache](s)
+	if !errors.Is(err, ErrLifetimeViolation) {
+		t.Errorf("Forever value depending on a created scope value should fail: %v", err)
+	}
+
+	handler, err := GetScoped[Handler](s)
+	if err != nil || handler.Request == nil {
+		t.Errorf("Once value depending on a scope value should be created: %v", err)
+	}
+
+	validated := New()
+	ProvideScoped(validated, Provider[Request]{
+		Lifetime: LifetimeScope,
+		Create: func(scope *Scope) (*Request, error) {
+			return &Request{}, nil
+		},
+	})
+	ProvideFunc(validated, func(request *Request) *Cache {
+		return &Cache{Request: request}
+	})
+	if err := validated.Validate(); !strings.Contains(fmt.Sprint(err), ErrLifetimeViolation.Error()) {
+		t.Errorf("Validate should report lifetime violations: %v", err)
+	}
+}
+
+func TestLifetimeViolationProvideValue(t *testing.T) {
+	type Database struct {
+		Host string
+	}
+	type Repository struct {
+		Database *Database
+	}
+
+	s := New()
+	ProvideValue(s, &Database{Host: "localhost"}, nil)
+	if err := ProvideFunc(s, func(db *Database) *Repository {
+		return &Repository{Database: db}
+	}); err != nil {
+		t.Fatalf("ProvideFunc failed: %v", err)
+	}
+
+	repository, err := GetScoped[Repository](s)
+	if err != nil || repository.Database.Host != "localhost" {
+		t.Errorf("Forever value depending on a value from ProvideValue should be created: %v", err)
+	}
+}
+
+func TestGetOr(t *testing.T) {
+	type Config struct{ Name string }
+
+	s := New()
+	ProvideScoped(s, Provider[Config]{
+		Create: func(scope *Scope) (*Config, error) {
+			return &Config{Name: "provided"}, nil
+		},
+	})
+	supplied := 0
+	supply := func() *Config {
+		supplied++
+		return &Config{Name: "supplied"}
+	}
+
+	config, err := GetOr(s, supply)
+	if err != nil || config.Name != "provided" || supplied != 0 {
+		t.Errorf("Supply should not be called when a provider exists: %v %d %v", config.Name, supplied, err)
+	}
+
+	empty := New()
+	config, _ = GetOr(empty, supply)
+	again, _ := GetOr(empty, supply)
+	if config.Name != "supplied" || again != config || supplied != 1 {
+		t.Errorf("Supplied value should be set once: %v %d", config.Name, supplied)
+	}
+
+	if config, err := GetOr(New(), func() *Config { return nil }); config != nil || err != nil {
+		t.Errorf("Nil supplied value should be returned: %v", err)
+	}
+
+	unset := New()
+	if config, _ := GetOrSet[Config](unset, nil); config != nil || CanResolve[Config](unset) {
+		t.Errorf("Nil value should not be set")
+	}
+
+	failing := New()
+	ProvideScoped(failing, Provider[Config]{
+		Create: func(scope *Scope) (*Config, error) {
+			return nil, io.ErrClosedPipe
+		},
+	})
+	supplied = 0
+	if config, err := GetOr(failing, supply); !errors.Is(err, io.ErrClosedPipe) || config != nil || supplied != 0 {
+		t.Errorf("GetOr should return the error creating the value without supplying one: %v %v %d", config, err, supplied)
+	}
+
+	frozen := New()
+	frozen.Freeze()
+	if _, err := GetOr(frozen, supply); !errors.Is(err, ErrScopeFrozen) {
+		t.Errorf("GetOr should not set a value on a frozen scope: %v", err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	type Config struct{ Name string }
+	type Service struct{ Config *Config }
+
+	calls := []string{}
+	s := New()
+	s.Use(func(next func() (any, error), key reflect.Type) (any, error) {
+		calls = append(calls, "outer "+key.String())
+		return next()
+	})
+	ProvideScoped(s, Provider[Config]{
+		Create: func(scope *Scope) (*Config, error) {
+			return &Config{Name: "config"}, nil
+		},
+	})
+	child := s.Spawn()
+	child.Use(func(next func() (any, error), key reflect.Type) (any, error) {
+		calls = append(calls, "inner "+key.String())
+		value, err := next()
+		if service, ok := value.(*Service); ok {
+			service.Config = &Config{Name: "wrapped"}
+		}
+		return value, err
+	})
+	ProvideScoped(child, Provider[Service]{
+		Lifetime: LifetimeScope,
+		Create: func(scope *Scope) (*Service, error) {
+			config, err := GetScoped[Config](scope)
+			return &Service{Config: config}, err
+		},
+	})
+
+	service, err := GetScoped[Service](child)
+	if err != nil || service.Config.Name != "wrapped" {
+		t.Fatalf("Middleware should be able to change created values: %v", err)
+	}
+	expected := "outer deps.Service, inner deps.Service, outer deps.Config"
+	if strings.Join(calls, ", ") != expected {
+		t.Errorf("Unexpected middleware calls: %s", strings.Join(calls, ", "))
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	type DB struct{ Host string }
+	type Mailer struct{ From string }
+	type Options struct{ Debug bool }
+
+	s := New()
+	created := 0
+	ProvideScoped(s, Provider[DB]{
+		Create: func(scope *Scope) (*DB, error) {
+			created++
+			return &DB{}, nil
+		},
+	})
+
+	called := false
+	err := s.DryRun(
+		func(db *DB, options Options, scope *Scope) { called = true },
+		func(db DB, names ...string) { called = true },
+	)
+	if err != nil || called || created != 0 {
+		t.Errorf("DryRun should pass without calling functions or creating values: %v %v %d", err, called, created)
+	}
+
+	err = s.DryRun(
+		func(db *DB, mailer *Mailer) { called = true },
+		"handler",
+	)
+	multi, ok := err.(multiError)
+	if !ok || len(multi.errors) != 2 || !errors.Is(multi.errors[0], ErrNoProvider) || !strings.Contains(multi.errors[0].Error(), "*deps.Mailer") || !errors.Is(multi.errors[1], ErrNotFunc) {
+		t.Errorf("DryRun should report missing arguments and values which aren't functions: %v", err)
+	}
+	if !errors.Is(err, ErrNoProvider) || !errors.Is(err, ErrNotFunc) {
+		t.Errorf("DryRun should return an error wrapping each failure: %v", err)
+	}
+	if called {
+		t.Errorf("DryRun should not call functions")
+	}
+}
+
+func TestSwap(t *testing.T) {
+	type Config struct{ Version int }
+
+	s := New()
+	swapped := atomic.Int32{}
+	s.OnEvent = func(event Event) {
+		if event.Kind == EventSwapped {
+			swapped.Add(1)
+		}
+	}
+	if previous, err := Swap(s, &Config{Version: 0}); previous != nil || err != nil {
+		t.Errorf("Swap without a value should return nil: %v", err)
+	}
+
+	wg := sync.WaitGroup{}
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				config, err := GetScoped[Config](s)
+				if err != nil || config == nil || config.Version < 0 || config.Version > 100 {
+					t.Errorf("Concurrent Get should return a valid value: %v %v", config, err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 100; i++ {
+		previous, _ := Swap(s, &Config{Version: i})
+		if previous.Version != i-1 {
+			t.Errorf("Swap should return the previous value, got %d", previous.Version)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	config, _ := GetScoped[Config](s)
+	if config.Version != 100 || swapped.Load() != 101 {
+		t.Errorf("Get should return the last swapped value: %d %d", config.Version, swapped.Load())
+	}
+
+	if _, err := Swap[Config](s, nil); !errors.Is(err, ErrNilValue) {
+		t.Errorf("Swap with a nil value should fail: %v", err)
+	}
+	s.Freeze()
+	if _, err := Swap(s, &Config{Version: 101}); !errors.Is(err, ErrScopeFrozen) {
+		t.Errorf("Swap on a frozen scope should fail: %v", err)
+	}
+	config, _ = GetScoped[Config](s)
+	if config.Version != 100 {
+		t.Errorf("Failed swaps should not replace the value: %d", config.Version)
+	}
+}
+
+func TestProviderClone(t *testing.T) {
+	type Settings struct {
+		Theme string
+		Tabs  []string
+	}
+
+	created := 0
+	cloned := 0
+	s := New()
+	ProvideScoped(s, Provider[Settings]{
+		Lifetime: LifetimeScope,
+		Create: func(scope *Scope) (*Settings, error) {
+			created++
+			return &Settings{Theme: "dark", Tabs: []string{"home"}}, nil
+		},
+		Clone: func(scope *Scope, template *Settings) (*Settings, error) {
+			cloned++
+			clone := *template
+			clone.Tabs = append([]string{}, template.Tabs...)
+			return &clone, nil
+		},
+	})
+	template, _ := GetScoped[Settings](s)
+
+	first, _ := GetScoped[Settings](s.Spawn())
+	second, _ := GetScoped[Settings](s.Spawn())
+	if created != 1 || cloned != 2 {
+		t.Errorf("Children should clone the template instead of creating: created %d cloned %d", created, cloned)
+	}
+	if first == template || second == template || first == second {
+		t.Errorf("Each child should get a distinct clone")
+	}
+	first.Tabs[0] = "changed"
+	if first.Theme != "dark" || second.Tabs[0] != "home" || template.Tabs[0] != "home" {
+		t.Errorf("Clones should be copies of the template")
+	}
+
+	other := New()
+	ProvideScoped(other, Provider[Settings]{
+		Lifetime: LifetimeScope,
+		Create: func(scope *Scope) (*Settings, error) {
+			created++
+			return &Settings{}, nil
+		},
+		Clone: func(scope *Scope, template *Settings) (*Settings, error) {
+			cloned++
+			return template, nil
+		},
+	})
+	GetScoped[Settings](other.Spawn())
+	if created != 2 || cloned != 2 {
+		t.Errorf("Create should be used without a template")
+	}
+}
+
+func TestInvokeGroupSlice(t *testing.T) {
+	s := New()
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		ProvideGroupScoped(s, Provider[Handler]{
+			Create: func(scope *Scope) (*Handler, error) {
+				var h Handler = namedHandler(name)
+				return &h, nil
+			},
+		})
+	}
+
+	result, err := s.Invoke(func(hs []Handler) string {
+		names := ""
+		for _, h := range hs {
+			names += h.Handle()
+		}
+		return names
+	})
+	if err != nil || result[0] != "abc" {
+		t.Errorf("Invoke should be given every member of the group: %v %v", result, err)
+	}
+
+	result, err = New().Invoke(func(hs []Handler) bool {
+		return hs == nil
+	})
+	if err != nil || result[0] != true {
+		t.Errorf("Invoke without a group should be given a nil slice: %v %v", result, err)
+	}
+}
+
+func TestInvokeArgError(t *testing.T) {
+	type Config struct {
+		Host string
+	}
+	type Database struct{}
+
+	failure := errors.New("connection refused")
+
+	s := New()
+	ProvideScoped(s, Provider[Config]{
+		Create: func(scope *Scope) (*Config, error) {
+			return nil, nil
+		},
+	})
+	ProvideScoped(s, Provider[Database]{
+		Create: func(scope *Scope) (*Database, error) {
+			return nil, failure
+		},
+	})
+
+	_, err := s.Invoke(func(name string, config Config) {})
+
+	var argErr *InvokeArgError
+	if !errors.As(err, &argErr) {
+		t.Fatalf("Invoke should return an InvokeArgError: %v", err)
+	}
+	if argErr.Index != 1 || argErr.Type != TypeOf[Config]() {
+		t.Errorf("InvokeArgError should record the index and type: %d %v", argErr.Index, argErr.Type)
+	}
+	if !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("InvokeArgError for a nil value should be ErrInvalidValue: %v", err)
+	}
+
+	_, err = s.Invoke(func(name string, count int, db *Database) {})
+
+	if !errors.As(err, &argErr) {
+		t.Fatalf("Invoke should return an InvokeArgError: %v", err)
+	}
+	if argErr.Index != 2 || argErr.Type != TypeOf[*Database]() {
+		t.Errorf("InvokeArgError should record the index and type: %d %v", argErr.Index, argErr.Type)
+	}
+	if !errors.Is(err, failure) {
+		t.Errorf("InvokeArgError should unwrap to the resolution error: %v", err)
+	}
+}
@@ -1,3 +1,3 @@
 module github.com/ClickerMonkey/deps
 
-go 1.19
+go 1.24